@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyFile  string
+	applyPrune bool
+)
+
+func applyCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "apply -f <directory.yaml>",
+		Short: "make users and groups match a declarative spec",
+		Run:   apply,
+	}
+	cmd.Flags().StringVarP(&applyFile, "file", "f", "", "yaml spec of users and groups")
+	cmd.Flags().BoolVar(&applyPrune, "prune", false, "delete managed users and groups missing from the spec")
+	cmd.MarkFlagRequired("file")
+	return &cmd
+}
+
+func apply(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	err := utils.Apply(client, applyFile, applyPrune, func(plan []utils.PlanAction) bool {
+		return confirm("apply %d changes?", len(plan))
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupOutput     string
+	restoreSnapshot  string
+	restoreOnly      string
+	restoreOverwrite bool
+)
+
+func backupCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "backup",
+		Short: "write a compressed snapshot of the user and group containers",
+		Run:   backup,
+	}
+	cmd.Flags().StringVarP(&backupOutput, "output", "o", ".", "snapshot file, or directory of a timestamped one")
+	return &cmd
+}
+
+func restoreCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "restore --snapshot <file> [--only user|group <name>...]",
+		Short: "restore entries of a backup snapshot, reporting conflicts with the directory",
+		Run:   restore,
+	}
+	cmd.Flags().StringVar(&restoreSnapshot, "snapshot", "", "snapshot written by backup")
+	cmd.Flags().StringVar(&restoreOnly, "only", "", "restore only the users or groups named in the arguments: user or group")
+	cmd.Flags().BoolVar(&restoreOverwrite, "overwrite", false, "replace attributes of conflicting entries with the snapshot's")
+	cmd.MarkFlagRequired("snapshot")
+	return &cmd
+}
+
+func backup(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	file, err := utils.BackupTo(client, backupOutput)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(file)
+}
+
+func restore(cmd *cobra.Command, args []string) {
+	if (restoreOnly == "") != (len(args) == 0) {
+		fmt.Println("ERROR: ", "--only takes user or group and the names to restore")
+		os.Exit(1)
+	}
+	client := newClient()
+	if restoreOverwrite && !confirm("replace conflicting entries with those of %s?", restoreSnapshot) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
+
+	data, err := utils.RestoreFrom(client, restoreSnapshot, restoreOnly, args, restoreOverwrite)
+	if data != "" {
+		fmt.Println(data)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
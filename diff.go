@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffFrom        string
+	diffTo          string
+	diffIgnore      []string
+	diffNoDefaults  bool
+	diffJSON        bool
+	connectionsFile string
+)
+
+func diffCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "diff --from <source> --to <source>",
+		Short: "compare users and groups of two directories or snapshots",
+		Long: `Sources are profile:<name> (a connection of the connections file),
+ldap://host:port or ldaps://host:port (with the global bind flags), an LDIF
+export or a json array of entries. An empty source is the directory of the
+global flags. Exits with 1 if the sources differ.`,
+		Run: diff,
+	}
+	cmd.Flags().StringVar(&diffFrom, "from", "", "source compared against")
+	cmd.Flags().StringVar(&diffTo, "to", "", "source compared")
+	cmd.Flags().StringSliceVar(&diffIgnore, "ignore", nil, "attributes not compared, repeatable")
+	cmd.Flags().BoolVar(&diffNoDefaults, "no-default-ignore", false, "also compare operational attributes like sambaPwdLastSet")
+	cmd.Flags().BoolVar(&diffJSON, "json", false, "print as json")
+	cmd.Flags().StringVar(&connectionsFile, "connections", utils.DefaultConnectionsFile(), "yaml file of named connections")
+	return &cmd
+}
+
+// diffSource ... directory or snapshot file named by source
+func diffSource(source string) utils.DiffSource {
+	client := newClient()
+	switch {
+	case source == "":
+	case strings.HasPrefix(source, "profile:"):
+		conn, err := utils.LookupConnection(connectionsFile, strings.TrimPrefix(source, "profile:"))
+		if err == nil {
+			err = conn.Apply(client)
+		}
+		if err != nil {
+			fmt.Println("ERROR: ", err.Error())
+			os.Exit(2)
+		}
+	case strings.HasPrefix(source, "ldap://"), strings.HasPrefix(source, "ldaps://"), strings.HasPrefix(source, "ldapi://"):
+		client.Addr = source
+		client.WriteAddr = ""
+	default:
+		return utils.DiffSource{Client: client, Path: source}
+	}
+	return utils.DiffSource{Client: client}
+}
+
+func diff(cmd *cobra.Command, args []string) {
+	ignore := diffIgnore
+	if !diffNoDefaults {
+		ignore = append(ignore, utils.DefaultDiffIgnore...)
+	}
+	data, entries, err := utils.Diff(diffSource(diffFrom), diffSource(diffTo), ignore, diffJSON)
+	if err != nil {
+		os.Exit(2)
+	}
+	if data != "" {
+		fmt.Println(data)
+	}
+	if len(entries) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportBases     []string
+	exportFilter    string
+	exportOutput    string
+	continueOnError bool
+)
+
+func exportCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "export",
+		Short: "dump users and groups, or any subtree, as LDIF",
+		Run:   exportLDIF,
+	}
+	cmd.Flags().StringSliceVar(&exportBases, "base", nil, "subtree to export, repeatable (default the user and group containers)")
+	cmd.Flags().StringVar(&exportFilter, "filter", "(objectClass=*)", "filter of exported entries")
+	cmd.Flags().StringVarP(&exportOutput, "output", "o", "", "LDIF file to write (default stdout)")
+	return &cmd
+}
+
+func importCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "import <file.ldif>",
+		Short: "apply add, modify, delete and modrdn records of an LDIF file",
+		Args:  cobra.ExactArgs(1),
+		Run:   importLDIF,
+	}
+	cmd.Flags().BoolVar(&continueOnError, "continue-on-error", false, "apply the remaining records after a failure")
+	return &cmd
+}
+
+func exportLDIF(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	err := utils.Export(client, exportBases, exportFilter, exportOutput)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func importLDIF(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	err := utils.ImportLDIF(client, args[0], continueOnError, func(deletes int, renames int) bool {
+		return confirm("apply %d delete and %d rename records?", deletes, renames)
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	mapType        string
+	mapRid         int
+	mapSid         string
+	mapDisplayName string
+	mapSidList     []string
+)
+
+func groupMapCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "map <subcommand>",
+		Short: "samba group mapping commands",
+	}
+	cmd.AddCommand(addGroupMapCommand())
+	cmd.AddCommand(modGroupMapCommand())
+	cmd.AddCommand(delGroupMapCommand())
+	cmd.AddCommand(bootstrapGroupMapCommand())
+	return cmd
+}
+
+func addGroupMappingFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&mapType, "type", "domain", "sambaGroupType: domain, local or well-known")
+	cmd.Flags().IntVar(&mapRid, "rid", 0, "rid in the samba domain (default from gidNumber)")
+	cmd.Flags().StringVar(&mapSid, "sid", "", "full sambaSID, overrides --rid")
+	cmd.Flags().StringVar(&mapDisplayName, "display-name", "", "displayName of the mapping")
+	cmd.Flags().StringSliceVar(&mapSidList, "sid-list", nil, "sambaSIDList of a local group, comma separated")
+}
+
+func addGroupMapCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "add <name>",
+		Short: "map existing posix group to a samba group",
+		Run:   addGroupMap,
+	}
+	addGroupMappingFlags(&cmd)
+	return &cmd
+}
+
+func modGroupMapCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "mod <name>",
+		Short: "change samba mapping of group",
+		Run:   modGroupMap,
+	}
+	addGroupMappingFlags(&cmd)
+	return &cmd
+}
+
+func delGroupMapCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "del <name>",
+		Short: "remove samba mapping of group, keeping the posix group",
+		Run:   delGroupMap,
+	}
+	return &cmd
+}
+
+func bootstrapGroupMapCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "bootstrap",
+		Short: "create Domain Admins, Domain Users and Domain Guests",
+		Run:   bootstrapGroupMap,
+	}
+	return &cmd
+}
+
+func parseGroupType() int {
+	groupType, err := utils.ParseGroupType(mapType)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		os.Exit(1)
+	}
+	return groupType
+}
+
+func addGroupMap(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	m := utils.GroupMapping{
+		SID:         mapSid,
+		RID:         mapRid,
+		Type:        parseGroupType(),
+		DisplayName: mapDisplayName,
+		SIDList:     mapSidList,
+	}
+	err := utils.MapGroup(client, args[0], m)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func modGroupMap(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	attrs := map[string][]string{}
+	if cmd.Flags().Changed("type") {
+		attrs["sambaGroupType"] = []string{strconv.Itoa(parseGroupType())}
+	}
+	if cmd.Flags().Changed("sid") {
+		attrs["sambaSID"] = []string{mapSid}
+	} else if cmd.Flags().Changed("rid") {
+		sid, err := utils.DomainSid(client, mapRid)
+		if err != nil {
+			os.Exit(1)
+		}
+		attrs["sambaSID"] = []string{sid}
+	}
+	if cmd.Flags().Changed("display-name") {
+		attrs["displayName"] = []string{mapDisplayName}
+	}
+	if cmd.Flags().Changed("sid-list") {
+		attrs["sambaSIDList"] = mapSidList
+	}
+	err := utils.ModGroupMapping(client, args[0], attrs)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func delGroupMap(cmd *cobra.Command, args []string) {
+	client := newClient()
+	if !confirm("remove samba mapping of group %s?", args[0]) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
+
+	err := utils.UnmapGroup(client, args[0])
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func bootstrapGroupMap(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	data, err := utils.BootstrapGroupMappings(client)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var idJSON bool
+
+func idCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "id <name>",
+		Short: "print user and group ids of user, like coreutils id",
+		Run:   getIdentity,
+	}
+	cmd.Flags().BoolVar(&idJSON, "json", false, "print as json")
+	return &cmd
+}
+
+func getIdentity(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	data, err := utils.GetIdentity(client, args[0], idJSON)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
+}
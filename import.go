@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	importFile     string
+	importFormat   string
+	importColumns  []string
+	importUIDStart int
+	importGid      string
+	importPwLength int
+)
+
+func importUsersCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "import --file <people.csv|json|yaml>",
+		Short: "add users from a csv, json or yaml file",
+		Run:   importUsers,
+	}
+	cmd.Flags().StringVar(&importFile, "file", "", "file with one user per row")
+	cmd.Flags().StringVar(&importFormat, "format", "", "csv, json or yaml (default from the file extension)")
+	cmd.Flags().StringSliceVar(&importColumns, "map", nil, "column=field mapping, fields are name, uidNumber, gidNumber, password, groups or an attribute, - drops the column")
+	cmd.Flags().IntVar(&importUIDStart, "uid-start", 1000, "lowest uidNumber allocated to rows without one")
+	cmd.Flags().StringVar(&importGid, "gid", "0", "gidNumber of rows without one")
+	cmd.Flags().IntVar(&importPwLength, "password-length", 16, "length of passwords generated for rows without one")
+	addSambaTemplateFlags(&cmd)
+	return &cmd
+}
+
+func importUsers(cmd *cobra.Command, args []string) {
+	client := newClient()
+	if importFile == "" {
+		fmt.Println("ERROR: ", "--file is required")
+		os.Exit(1)
+	}
+	columns, err := utils.ParseColumnMap(importColumns)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		os.Exit(1)
+	}
+
+	client.SambaTemplates = sambaTemplates
+	opts := utils.ImportOptions{
+		Format:         importFormat,
+		Columns:        columns,
+		UIDStart:       importUIDStart,
+		GIDNumber:      importGid,
+		PasswordLength: importPwLength,
+	}
+	data, err := utils.ImportUsers(client, importFile, opts)
+	if data != "" {
+		fmt.Println(data)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
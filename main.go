@@ -1,19 +1,52 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 	"userctl/utils"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	url     string
-	basedn  string
-	admin   string
-	adminpw string
+	url        string
+	basedn     string
+	admin      string
+	adminpw    string
+	dryRun     bool
+	yes        bool
+	idempotent bool
+	membership string
+	profile    string
+	noSamba    bool
+	sambaDom   string
+	ridAlloc   string
+	timeout    time.Duration
+	writeURL   string
+	balance    string
+)
+
+// interrupted ... done on the first SIGINT, see handleInterrupt
+var interrupted, interrupt = context.WithCancel(context.Background())
+
+var (
+	delArchive     string
+	delTrash       bool
+	purgeRetention time.Duration
+	delGroupForce  bool
+	reassignTo     string
+	membersFile    string
+	recursive      bool
+	effective      bool
+	memberGroups   bool
+	userGid        string
+	sambaTemplates utils.SambaTemplates
 )
 
 var (
@@ -26,6 +59,82 @@ var (
 	}
 )
 
+// newClient ... client configured from the global flags
+func newClient() *utils.LDAPClient {
+	switch membership {
+	case "", utils.MembershipUID, utils.MembershipDN, utils.MembershipUniqueDN, utils.MembershipBoth:
+	default:
+		fmt.Println("ERROR: ", "unknown membership "+membership)
+		os.Exit(1)
+	}
+	if ridAlloc != utils.RidAlgorithmic && ridAlloc != utils.RidNextRid {
+		fmt.Println("ERROR: ", "unknown rid allocation "+ridAlloc)
+		os.Exit(1)
+	}
+	if balance != utils.BalanceOrder && balance != utils.BalanceRoundRobin {
+		fmt.Println("ERROR: ", "unknown balance "+balance)
+		os.Exit(1)
+	}
+	p, err := utils.LookupProfile(profile)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		os.Exit(1)
+	}
+	client := &utils.LDAPClient{
+		Addr:          url,
+		WriteAddr:     writeURL,
+		Balance:       balance,
+		BaseDn:        basedn,
+		BindDn:        admin,
+		BindPass:      adminpw,
+		TLS:           false,
+		StartTLS:      false,
+		DryRun:        dryRun,
+		Idempotent:    idempotent,
+		Membership:    membership,
+		Profile:       p,
+		NoSamba:       noSamba,
+		SambaDomain:   sambaDom,
+		RidAllocation: ridAlloc,
+		Timeout:       timeout}
+	return client.WithContext(interrupted)
+}
+
+// handleInterrupt ... the first SIGINT closes the ldap connections, failing
+// the requests in flight so transactions roll back, the second exits at once
+func handleInterrupt() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "interrupted, press ^C again to exit immediately")
+		interrupt()
+		<-signals
+		os.Exit(130)
+	}()
+}
+
+// confirm ... ask before a destructive operation, unless --yes or --dry-run
+func confirm(format string, a ...interface{}) bool {
+	if yes || dryRun {
+		return true
+	}
+	fmt.Printf(format+" [y/N] ", a...)
+	answers := make(chan string, 1)
+	go func() {
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answers <- answer
+	}()
+	select {
+	case answer := <-answers:
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	case <-interrupted.Done():
+		fmt.Println()
+		return false
+	}
+}
+
 func userCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "user <subcommand>",
@@ -35,8 +144,13 @@ func userCommand() *cobra.Command {
 	cmd.AddCommand(getUserByIDCommand())
 	cmd.AddCommand(getUserByNameCommand())
 	cmd.AddCommand(addUserCommand())
+	cmd.AddCommand(importUsersCommand())
 	cmd.AddCommand(modUserPwdCommand())
+	cmd.AddCommand(modUserCommand())
 	cmd.AddCommand(delUserCommand())
+	cmd.AddCommand(restoreUserCommand())
+	cmd.AddCommand(purgeUsersCommand())
+	cmd.AddCommand(getUserGroupsCommand())
 	return cmd
 }
 
@@ -67,21 +181,73 @@ func getUserByNameCommand() *cobra.Command {
 	return &cmd
 }
 
+func getUserGroupsCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "groups <name>",
+		Short: "get groups of user, primary group first",
+		Run:   getUserGroups,
+	}
+	cmd.Flags().BoolVar(&effective, "effective", false, "include groups inherited through nested groups")
+	return &cmd
+}
+
 func addUserCommand() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "add <name> <id> <password>",
 		Short: "add user",
 		Run:   addUser,
 	}
+	cmd.Flags().StringVar(&userGid, "gid", "0", "gidNumber of the primary group")
+	addSambaTemplateFlags(&cmd)
+	return &cmd
+}
+
+func modUserCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "mod <name>",
+		Short: "mod primary group and samba profile attributes of user",
+		Run:   modUser,
+	}
+	cmd.Flags().StringVar(&userGid, "gid", "", "gidNumber of the primary group")
+	addSambaTemplateFlags(&cmd)
 	return &cmd
 }
 
+// addSambaTemplateFlags ... flags of the samba profile attributes, %u is replaced by the username
+func addSambaTemplateFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&sambaTemplates.HomePath, "samba-home-path", "", "sambaHomePath, e.g. \\\\fileserver\\%u")
+	cmd.Flags().StringVar(&sambaTemplates.ProfilePath, "samba-profile-path", "", "sambaProfilePath, e.g. \\\\fileserver\\profiles\\%u")
+	cmd.Flags().StringVar(&sambaTemplates.LogonScript, "samba-logon-script", "", "sambaLogonScript")
+	cmd.Flags().StringVar(&sambaTemplates.HomeDrive, "samba-home-drive", "", "sambaHomeDrive, e.g. H:")
+}
+
 func delUserCommand() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "del <name>",
 		Short: "del user",
 		Run:   delUser,
 	}
+	cmd.Flags().StringVar(&delArchive, "archive", "", "append the entry to this LDIF file before deletion")
+	cmd.Flags().BoolVar(&delTrash, "trash", false, "move the entry to ou=Deleted instead of deleting it")
+	return &cmd
+}
+
+func restoreUserCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "restore <name>",
+		Short: "restore user from ou=Deleted",
+		Run:   restoreUser,
+	}
+	return &cmd
+}
+
+func purgeUsersCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "purge",
+		Short: "delete users kept in ou=Deleted longer than the retention",
+		Run:   purgeUsers,
+	}
+	cmd.Flags().DurationVar(&purgeRetention, "retention", 30*24*time.Hour, "how long deleted users are kept")
 	return &cmd
 }
 
@@ -95,13 +261,7 @@ func modUserPwdCommand() *cobra.Command {
 }
 
 func getAllUsers(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
+	client := newClient()
 	data, err := utils.GetUsers(client)
 	if err != nil {
 		os.Exit(1)
@@ -110,13 +270,7 @@ func getAllUsers(cmd *cobra.Command, args []string) {
 }
 
 func getUserByID(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
+	client := newClient()
 
 	id, err := strconv.Atoi(args[0])
 	if err != nil {
@@ -130,13 +284,7 @@ func getUserByID(cmd *cobra.Command, args []string) {
 }
 
 func getUserByName(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
+	client := newClient()
 
 	data, err := utils.GetUserByName(client, args[0])
 	if err != nil {
@@ -145,44 +293,102 @@ func getUserByName(cmd *cobra.Command, args []string) {
 	fmt.Println(data)
 }
 
+func getUserGroups(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	data, err := utils.UserGroups(client, args[0], effective)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
+}
+
 func addUser(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
-
-	err := utils.AddUser(client, args[0], args[1], args[2])
+	client := newClient()
+
+	client.SambaTemplates = sambaTemplates
+	spec := utils.UserSpec{Name: args[0], UIDNumber: args[1], GIDNumber: userGid, Password: args[2]}
+	data, err := utils.AddUser(client, spec)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
+}
+
+func modUser(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	attrs := make(map[string][]string)
+	flags := map[string]string{
+		"gid":                "gidNumber",
+		"samba-home-path":    "sambaHomePath",
+		"samba-profile-path": "sambaProfilePath",
+		"samba-logon-script": "sambaLogonScript",
+		"samba-home-drive":   "sambaHomeDrive",
+	}
+	for flag, attr := range flags {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		value := utils.ExpandSambaTemplate(cmd.Flags().Lookup(flag).Value.String(), args[0])
+		if value == "" && flag == "gid" {
+			fmt.Println("ERROR: ", "--gid cannot be empty, a user always has a primary group")
+			os.Exit(1)
+		}
+		if value == "" {
+			attrs[attr] = []string{}
+		} else {
+			attrs[attr] = []string{value}
+		}
+	}
+	err := utils.ModUser(client, args[0], attrs)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func delUser(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
-
-	err := utils.DelUser(client, args[0])
+	client := newClient()
+	if !confirm("delete user %s?", args[0]) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
+
+	opts := utils.DelUserOptions{Archive: delArchive, Trash: delTrash}
+	err := utils.DelUser(client, args[0], opts)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func restoreUser(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	err := utils.RestoreUser(client, args[0])
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func purgeUsers(cmd *cobra.Command, args []string) {
+	client := newClient()
+	if !confirm("purge users deleted more than %s ago?", purgeRetention) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
+
+	err := utils.PurgeTrash(client, purgeRetention)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func modUserPwd(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
+	client := newClient()
+	if !confirm("change password of user %s?", args[0]) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
 
 	err := utils.ModUserPwd(client, args[0], args[1])
 	if err != nil {
@@ -197,10 +403,13 @@ func groupCommand() *cobra.Command {
 	}
 	cmd.AddCommand(getAllGroupsCommand())
 	cmd.AddCommand(getGroupByNameCommand())
+	cmd.AddCommand(getGroupMembersCommand())
 	cmd.AddCommand(addGroupCommand())
 	cmd.AddCommand(delGroupCommand())
 	cmd.AddCommand(addGroupMemberCommand())
 	cmd.AddCommand(delGroupMemberCommand())
+	cmd.AddCommand(setGroupMembersCommand())
+	cmd.AddCommand(groupMapCommand())
 	return cmd
 }
 
@@ -222,6 +431,16 @@ func getGroupByNameCommand() *cobra.Command {
 	return &cmd
 }
 
+func getGroupMembersCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "members <name>",
+		Short: "get members of group with user details",
+		Run:   getGroupMembers,
+	}
+	cmd.Flags().BoolVar(&recursive, "recursive", false, "include members of nested groups")
+	return &cmd
+}
+
 func addGroupCommand() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "add <name> <id>",
@@ -237,35 +456,58 @@ func delGroupCommand() *cobra.Command {
 		Short: "del group",
 		Run:   delGroup,
 	}
+	cmd.Flags().BoolVar(&delGroupForce, "force", false, "delete the group even if it is still in use")
+	cmd.Flags().StringVar(&reassignTo, "reassign-to", "", "move users having the group as primary group to this group")
 	return &cmd
 }
 
 func addGroupMemberCommand() *cobra.Command {
 	cmd := cobra.Command{
-		Use:   "addMember <groupname> <username>",
-		Short: "add user to group",
+		Use:   "addMember <groupname> <username>...",
+		Short: "add users to group",
 		Run:   addGroupMember,
 	}
+	cmd.Flags().StringVar(&membersFile, "from-file", "", "read usernames from file, one per line")
+	cmd.Flags().BoolVar(&memberGroups, "group", false, "add the given names as nested groups instead of users")
 	return &cmd
 }
 
 func delGroupMemberCommand() *cobra.Command {
 	cmd := cobra.Command{
-		Use:   "delMember <groupname> <username>",
-		Short: "del user from group",
+		Use:   "delMember <groupname> <username>...",
+		Short: "del users from group",
 		Run:   delGroupMember,
 	}
+	cmd.Flags().StringVar(&membersFile, "from-file", "", "read usernames from file, one per line")
+	return &cmd
+}
+
+func setGroupMembersCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "setMembers <groupname> <username>...",
+		Short: "replace members of group",
+		Run:   setGroupMembers,
+	}
+	cmd.Flags().StringVar(&membersFile, "from-file", "", "read usernames from file, one per line")
 	return &cmd
 }
 
+// memberArgs ... usernames from the arguments after the group and --from-file
+func memberArgs(args []string) []string {
+	usernames := args[1:]
+	if membersFile != "" {
+		names, err := utils.ReadNameList(membersFile)
+		if err != nil {
+			fmt.Println("ERROR: ", err.Error())
+			os.Exit(1)
+		}
+		usernames = append(usernames, names...)
+	}
+	return usernames
+}
+
 func getAllGroups(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
+	client := newClient()
 	data, err := utils.GetGroups(client)
 	if err != nil {
 		os.Exit(1)
@@ -274,13 +516,7 @@ func getAllGroups(cmd *cobra.Command, args []string) {
 }
 
 func getGroupByName(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
+	client := newClient()
 	data, err := utils.GetGroupByName(client, args[0])
 	if err != nil {
 		os.Exit(1)
@@ -288,72 +524,109 @@ func getGroupByName(cmd *cobra.Command, args []string) {
 	fmt.Println(data)
 }
 
+func getGroupMembers(cmd *cobra.Command, args []string) {
+	client := newClient()
+	data, err := utils.GroupMembers(client, args[0], recursive)
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
+}
+
 func addGroup(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
-
-	err := utils.AddGroup(client, args[0], args[1])
+	client := newClient()
+
+	data, err := utils.AddGroup(client, args[0], args[1])
 	if err != nil {
 		os.Exit(1)
 	}
+	fmt.Println(data)
 }
 
 func delGroup(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
-
-	err := utils.DelGroup(client, args[0])
+	client := newClient()
+	if !confirm("delete group %s?", args[0]) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
+
+	opts := utils.DelGroupOptions{Force: delGroupForce, ReassignTo: reassignTo}
+	err := utils.DelGroup(client, args[0], opts)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func addGroupMember(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
-
-	err := utils.GroupAddMember(client, args[0], args[1])
+	client := newClient()
+
+	var data string
+	var err error
+	if memberGroups {
+		data, err = utils.GroupAddSubgroups(client, args[0], memberArgs(args))
+	} else {
+		data, err = utils.GroupAddMembers(client, args[0], memberArgs(args))
+	}
 	if err != nil {
 		os.Exit(1)
 	}
+	fmt.Println(data)
 }
 
 func delGroupMember(cmd *cobra.Command, args []string) {
-	client := &utils.LDAPClient{
-		Addr:     url,
-		BaseDn:   basedn,
-		BindDn:   admin,
-		BindPass: adminpw,
-		TLS:      false,
-		StartTLS: false}
-
-	err := utils.GroupDelMember(client, args[0], args[1])
+	client := newClient()
+	usernames := memberArgs(args)
+	if !confirm("remove %s from group %s?", strings.Join(usernames, ", "), args[0]) {
+		fmt.Println("aborted")
+		os.Exit(1)
+	}
+
+	data, err := utils.GroupDelMembers(client, args[0], usernames)
 	if err != nil {
 		os.Exit(1)
 	}
+	fmt.Println(data)
+}
+
+func setGroupMembers(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	data, err := utils.GroupSetMembers(client, args[0], memberArgs(args), func(del []string) bool {
+		return confirm("remove %s from group %s?", strings.Join(del, ", "), args[0])
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
 }
 
 func main() {
+	handleInterrupt()
 	rootCmd.AddCommand(userCommand())
 	rootCmd.AddCommand(groupCommand())
-	rootCmd.PersistentFlags().StringVar(&url, "url", "127.0.0.1:389", "ldap address")
+	rootCmd.AddCommand(idCommand())
+	rootCmd.AddCommand(sidCommand())
+	rootCmd.AddCommand(exportCommand())
+	rootCmd.AddCommand(importCommand())
+	rootCmd.AddCommand(applyCommand())
+	rootCmd.AddCommand(diffCommand())
+	rootCmd.AddCommand(syncCommand())
+	rootCmd.AddCommand(backupCommand())
+	rootCmd.AddCommand(restoreCommand())
+	rootCmd.PersistentFlags().StringVar(&url, "url", "127.0.0.1:389", "ldap servers, comma separated host:port, ldap://, ldaps:// or ldapi:// urls")
+	rootCmd.PersistentFlags().StringVar(&writeURL, "write-url", "", "servers taking writes, like a primary, while reads go to --url (default --url)")
+	rootCmd.PersistentFlags().StringVar(&balance, "balance", utils.BalanceOrder, "order of trying the servers: order or round-robin")
 	rootCmd.PersistentFlags().StringVar(&basedn, "baseDn", "dc=test,dc=com", "ldap basedn")
 	rootCmd.PersistentFlags().StringVar(&admin, "admin", "cn=manager,dc=test,dc=com", "ldap admin")
 	rootCmd.PersistentFlags().StringVar(&adminpw, "adminPw", "123456", "ldap admin password")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print ldap write operations instead of sending them")
+	rootCmd.PersistentFlags().BoolVarP(&yes, "yes", "y", false, "do not ask for confirmation")
+	rootCmd.PersistentFlags().StringVar(&membership, "membership", "", "group member attribute: memberUid, member, uniqueMember or both (default from profile)")
+	rootCmd.PersistentFlags().BoolVar(&noSamba, "no-samba", false, "create pure posix entries without samba attributes")
+	rootCmd.PersistentFlags().StringVar(&sambaDom, "samba-domain", "", "sambaDomainName used for sids (default the only domain)")
+	rootCmd.PersistentFlags().StringVar(&ridAlloc, "rid-allocation", utils.RidAlgorithmic, "samba rid allocation: algorithmic or nextrid")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", utils.ProfileOpenLDAPSamba.Name, "schema profile: "+strings.Join(utils.ProfileNames(), ", "))
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 30*time.Second, "timeout of connecting and of each ldap request, 0 waits forever")
+	rootCmd.PersistentFlags().BoolVar(&idempotent, "idempotent", false, "treat creating existing entries and no-op membership changes as unchanged")
 	rootCmd.Execute()
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	sidUID string
+	sidGID string
+)
+
+func sidCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sid <subcommand>",
+		Short: "translate between samba sids and users or groups",
+	}
+	cmd.AddCommand(lookupSidCommand())
+	cmd.AddCommand(sidOfCommand())
+	return cmd
+}
+
+func lookupSidCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "lookup <sid>",
+		Short: "find user or group of sid, or compute its uid/gid from the rid",
+		Args:  cobra.ExactArgs(1),
+		Run:   lookupSid,
+	}
+	return &cmd
+}
+
+func sidOfCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "of <user|group>",
+		Short: "print sid of user or group",
+		Run:   sidOf,
+	}
+	cmd.Flags().StringVar(&sidUID, "uid", "", "compute the sid of a uidNumber instead")
+	cmd.Flags().StringVar(&sidGID, "gid", "", "compute the sid of a gidNumber instead")
+	return &cmd
+}
+
+func lookupSid(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	data, err := utils.LookupSid(client, args[0])
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
+}
+
+func sidOf(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	var data string
+	var err error
+	switch {
+	case sidUID != "":
+		data, err = utils.ComputeSid(client, utils.SidUser, sidUID)
+	case sidGID != "":
+		data, err = utils.ComputeSid(client, utils.SidGroup, sidGID)
+	case len(args) == 1:
+		data, err = utils.SidOf(client, args[0])
+	default:
+		fmt.Println("ERROR: ", "need a user or group name, --uid or --gid")
+		os.Exit(1)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Println(data)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"userctl/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var localSync utils.LocalSyncOptions
+
+func syncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync <subcommand>",
+		Short: "one-way sync of accounts into ldap",
+	}
+	cmd.AddCommand(syncLocalCommand())
+	return cmd
+}
+
+func syncLocalCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "local",
+		Short: "create or update users and groups from passwd, group and shadow files",
+		Run:   syncLocal,
+	}
+	cmd.Flags().StringVar(&localSync.Passwd, "passwd", "/etc/passwd", "passwd file")
+	cmd.Flags().StringVar(&localSync.Group, "group", "/etc/group", "group file, empty to skip groups")
+	cmd.Flags().StringVar(&localSync.Shadow, "shadow", "", "shadow file with the password hashes")
+	cmd.Flags().IntVar(&localSync.UIDMin, "uid-min", 1000, "lowest uid synced")
+	cmd.Flags().IntVar(&localSync.UIDMax, "uid-max", 60000, "highest uid synced")
+	cmd.Flags().IntVar(&localSync.GIDMin, "gid-min", 1000, "lowest gid synced")
+	cmd.Flags().IntVar(&localSync.GIDMax, "gid-max", 60000, "highest gid synced")
+	return &cmd
+}
+
+func syncLocal(cmd *cobra.Command, args []string) {
+	client := newClient()
+
+	err := utils.SyncLocal(client, localSync, func(plan []utils.PlanAction) bool {
+		return confirm("apply %d changes?", len(plan))
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,587 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultMarker ... attribute value marking entries managed by apply
+const defaultMarker = "description=managed-by-userctl"
+
+// Plan actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// StringList ... a yaml scalar or list of scalars
+type StringList []string
+
+// UnmarshalYAML ... accept a single value as a list of one
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*l = list
+		return nil
+	}
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	*l = []string{value}
+	return nil
+}
+
+// UserState ... desired state of a user
+type UserState struct {
+	Name      string `yaml:"name"`
+	UIDNumber string `yaml:"uidNumber"`
+	GIDNumber string `yaml:"gidNumber"`
+	// Password is only used when the user is created, generated if empty
+	Password string `yaml:"password"`
+	// PasswordHash is a {SCHEME} userPassword value, set on creation and
+	// whenever it differs
+	PasswordHash string `yaml:"passwordHash"`
+	// Locked is left alone if unset
+	Locked     *bool                 `yaml:"locked"`
+	Groups     []string              `yaml:"groups"`
+	Attributes map[string]StringList `yaml:"attributes"`
+}
+
+// GroupState ... desired state of a group
+type GroupState struct {
+	Name      string `yaml:"name"`
+	GIDNumber string `yaml:"gidNumber"`
+	// Members, if set, are the exact members together with the users
+	// listing the group, otherwise members are only added
+	Members []string `yaml:"members"`
+}
+
+// PruneScope ... which entries missing from the spec --prune deletes
+type PruneScope struct {
+	// Marker is attr=value, set on entries created or adopted by apply
+	Marker string `yaml:"marker"`
+	// Base, if set, also prunes every user and group below it
+	Base string `yaml:"base"`
+}
+
+// DirectorySpec ... desired users and groups, as read from directory.yaml
+type DirectorySpec struct {
+	Users  []UserState  `yaml:"users"`
+	Groups []GroupState `yaml:"groups"`
+	Prune  PruneScope   `yaml:"prune"`
+}
+
+// ApplyOptions ... how a spec is applied
+type ApplyOptions struct {
+	// Prune deletes marked entries, and those below the prune base, that
+	// are not in the spec
+	Prune bool
+	// Unmarked leaves the prune marker off created and updated entries, for
+	// specs like those of SyncLocal that do not own what they touch
+	Unmarked bool
+}
+
+// PlanAction ... one change of a plan
+type PlanAction struct {
+	Action  string
+	Kind    string
+	Name    string
+	Changes []string
+	apply   func() error
+}
+
+// String ... +, ~ or - with kind, name and changed attributes
+func (a PlanAction) String() string {
+	sign := map[string]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}[a.Action]
+	line := fmt.Sprintf("%s %s %s", sign, a.Kind, a.Name)
+	if len(a.Changes) > 0 {
+		line += " (" + strings.Join(a.Changes, ", ") + ")"
+	}
+	return line
+}
+
+// ReadDirectorySpec ... parse a yaml (or json) desired state file
+func ReadDirectorySpec(path string) (spec DirectorySpec, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	err = yaml.UnmarshalStrict(content, &spec)
+	return
+}
+
+// marker ... attribute and value of the prune marker, defaultMarker if unset
+func (s DirectorySpec) marker() (attr string, value string, err error) {
+	marker := s.Prune.Marker
+	if marker == "" {
+		marker = defaultMarker
+	}
+	kv := strings.SplitN(marker, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		err = fmt.Errorf("invalid marker %s, want attr=value", marker)
+		return
+	}
+	return kv[0], kv[1], nil
+}
+
+// validate ... names are set and unique
+func (s DirectorySpec) validate() (err error) {
+	users := map[string]bool{}
+	for _, user := range s.Users {
+		if user.Name == "" {
+			return errors.New("user without name")
+		}
+		if users[user.Name] {
+			return fmt.Errorf("user %s listed twice", user.Name)
+		}
+		users[user.Name] = true
+	}
+	groups := map[string]bool{}
+	for _, group := range s.Groups {
+		if group.Name == "" {
+			return errors.New("group without name")
+		}
+		if groups[group.Name] {
+			return fmt.Errorf("group %s listed twice", group.Name)
+		}
+		groups[group.Name] = true
+	}
+	return
+}
+
+// sameValues ... whether a and b hold the same values in any order
+func sameValues(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as := append([]string{}, a...)
+	bs := append([]string{}, b...)
+	sort.Strings(as)
+	sort.Strings(bs)
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// markerRequest ... modify request adding the marker to dn
+func markerRequest(dn string, attr string, value string) *ldap.ModifyRequest {
+	modify := ldap.NewModifyRequest(dn)
+	modify.Add(attr, []string{value})
+	return modify
+}
+
+// PlanApply ... actions turning the directory into spec: groups, users,
+// memberships, then with opts.Prune deletions of unlisted marked entries
+func (lc *LDAPClient) PlanApply(spec DirectorySpec, opts ApplyOptions) (plan []PlanAction, err error) {
+	if err = spec.validate(); err != nil {
+		return
+	}
+	if opts.Prune && opts.Unmarked {
+		err = errors.New("pruning finds entries by the marker, it cannot leave it off")
+		return
+	}
+	markerAttr, markerValue, err := spec.marker()
+	if err != nil {
+		return
+	}
+	mark := !opts.Unmarked
+	memberAttrs := lc.memberAttrs()
+
+	groupExists := map[string]bool{}
+	currentMembers := map[string][]string{}
+	for _, group := range spec.Groups {
+		group := group
+		filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(group.Name))
+		data, serr := lc.SearchEntries(filter, append([]string{"gidNumber", markerAttr}, memberAttrs...), lc.GroupDn())
+		if serr != nil {
+			err = serr
+			return
+		}
+		if len(data) == 0 {
+			if group.GIDNumber == "" {
+				err = fmt.Errorf("group %s needs a gidNumber to be created", group.Name)
+				return
+			}
+			attrs := map[string][]string{}
+			if mark {
+				attrs[markerAttr] = []string{markerValue}
+			}
+			plan = append(plan, PlanAction{Action: ActionCreate, Kind: "group", Name: group.Name, apply: func() error {
+				return lc.AddGroupWithAttributes(group.Name, group.GIDNumber, attrs)
+			}})
+			continue
+		}
+		entry := data[0]
+		groupExists[group.Name] = true
+		if currentMembers[group.Name], err = lc.memberNames(entry); err != nil {
+			return
+		}
+		if gid := entry.Values("gidNumber"); group.GIDNumber != "" && len(gid) > 0 && gid[0] != group.GIDNumber {
+			err = fmt.Errorf("group %s has gidNumber %s, not changing it to %s", group.Name, gid[0], group.GIDNumber)
+			return
+		}
+		if mark && !containsName(entry.Values(markerAttr), markerValue) {
+			plan = append(plan, PlanAction{Action: ActionUpdate, Kind: "group", Name: group.Name, Changes: []string{markerAttr}, apply: func() error {
+				return lc.Modify(markerRequest(entry.DN, markerAttr, markerValue))
+			}})
+		}
+	}
+
+	userListed := map[string]bool{}
+	for _, user := range spec.Users {
+		user := user
+		userListed[user.Name] = true
+		attrs := append([]string{"uidNumber", "gidNumber", markerAttr}, lc.lockAttrs()...)
+		for name := range user.Attributes {
+			attrs = append(attrs, name)
+		}
+		if user.PasswordHash != "" {
+			attrs = append(attrs, "userPassword")
+		}
+		data, serr := lc.SearchEntries(lc.userFilter(user.Name), attrs, lc.PeopleDn())
+		if serr != nil {
+			err = serr
+			return
+		}
+		if len(data) == 0 {
+			action, perr := lc.planCreateUser(user, mark, markerAttr, markerValue)
+			if perr != nil {
+				err = perr
+				return
+			}
+			plan = append(plan, action)
+			continue
+		}
+		entry := data[0]
+		if uid := entry.Values("uidNumber"); user.UIDNumber != "" && len(uid) > 0 && uid[0] != user.UIDNumber {
+			err = fmt.Errorf("user %s has uidNumber %s, not changing it to %s", user.Name, uid[0], user.UIDNumber)
+			return
+		}
+		replace := map[string][]string{}
+		changes := []string{}
+		if gid := entry.Values("gidNumber"); user.GIDNumber != "" && !sameValues(gid, []string{user.GIDNumber}) {
+			replace["gidNumber"] = []string{user.GIDNumber}
+			changes = append(changes, "gidNumber")
+		}
+		desired := stringLists(user.Attributes)
+		if values, ok := desired[markerAttr]; mark && ok && !containsName(values, markerValue) {
+			desired[markerAttr] = append(values, markerValue)
+		}
+		for _, name := range sortedAttrNames(desired) {
+			if !sameValues(entry.Values(name), desired[name]) {
+				replace[name] = desired[name]
+				changes = append(changes, name)
+			}
+		}
+		if user.PasswordHash != "" && !sameValues(entry.Values("userPassword"), []string{user.PasswordHash}) {
+			replace["userPassword"] = []string{user.PasswordHash}
+			changes = append(changes, "password")
+		}
+		if user.Locked != nil && lc.IsLocked(entry) != *user.Locked {
+			for name, values := range lc.lockAttributes(entry, *user.Locked) {
+				replace[name] = values
+			}
+			if *user.Locked {
+				changes = append(changes, "lock")
+			} else {
+				changes = append(changes, "unlock")
+			}
+		}
+		_, specMarker := desired[markerAttr]
+		addMarker := mark && !specMarker && !containsName(entry.Values(markerAttr), markerValue)
+		if addMarker {
+			changes = append(changes, markerAttr)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		plan = append(plan, PlanAction{Action: ActionUpdate, Kind: "user", Name: user.Name, Changes: changes, apply: func() error {
+			if err := lc.ModifyUser(user.Name, replace); err != nil {
+				return err
+			}
+			if addMarker {
+				return lc.Modify(markerRequest(entry.DN, markerAttr, markerValue))
+			}
+			return nil
+		}})
+	}
+
+	memberships, err := lc.planMemberships(spec, userListed, groupExists, currentMembers)
+	if err != nil {
+		return
+	}
+	plan = append(plan, memberships...)
+
+	if opts.Prune {
+		deletions, perr := lc.planPrune(spec, markerAttr, markerValue, userListed)
+		if perr != nil {
+			err = perr
+			return
+		}
+		plan = append(plan, deletions...)
+	}
+	return
+}
+
+// stringLists ... attributes of a spec as a plain map
+func stringLists(attrs map[string]StringList) map[string][]string {
+	out := map[string][]string{}
+	for name, values := range attrs {
+		out[name] = values
+	}
+	return out
+}
+
+// planCreateUser ... action creating user with marker if mark, generated password and lock state
+func (lc *LDAPClient) planCreateUser(user UserState, mark bool, markerAttr string, markerValue string) (action PlanAction, err error) {
+	if user.UIDNumber == "" {
+		err = fmt.Errorf("user %s needs a uidNumber to be created", user.Name)
+		return
+	}
+	generated := user.Password == "" && user.PasswordHash == ""
+	password := user.Password
+	if generated {
+		if password, err = GeneratePassword(defaultPasswordLength); err != nil {
+			return
+		}
+	}
+	attrs := stringLists(user.Attributes)
+	if mark {
+		attrs[markerAttr] = append(attrs[markerAttr], markerValue)
+	}
+	spec := UserSpec{Name: user.Name, UIDNumber: user.UIDNumber, GIDNumber: user.GIDNumber,
+		Password: password, PasswordHash: user.PasswordHash, Attributes: attrs}
+	action = PlanAction{Action: ActionCreate, Kind: "user", Name: user.Name, apply: func() error {
+		if err := lc.AddUserSpec(spec); err != nil {
+			return err
+		}
+		if generated && !lc.DryRun {
+			// a dry run created no user to log in with it
+			fmt.Printf("password of %s: %s\n", user.Name, password)
+		}
+		if user.Locked != nil && *user.Locked {
+			return lc.ModifyUser(user.Name, lc.lockAttributes(LdapResult{}, true))
+		}
+		return nil
+	}}
+	return
+}
+
+// planMemberships ... membership changes of the groups in spec and the groups users list
+func (lc *LDAPClient) planMemberships(spec DirectorySpec, userListed map[string]bool, groupExists map[string]bool, currentMembers map[string][]string) (plan []PlanAction, err error) {
+	specGroups := map[string]GroupState{}
+	for _, group := range spec.Groups {
+		specGroups[group.Name] = group
+	}
+	wanted := map[string][]string{}
+	names := []string{}
+	for _, group := range spec.Groups {
+		wanted[group.Name] = append(wanted[group.Name], group.Members...)
+		names = append(names, group.Name)
+	}
+	for _, user := range spec.Users {
+		for _, groupname := range user.Groups {
+			if _, ok := wanted[groupname]; !ok {
+				names = append(names, groupname)
+			}
+			wanted[groupname] = append(wanted[groupname], user.Name)
+		}
+	}
+
+	checked := map[string]bool{}
+	for _, groupname := range names {
+		group, inSpec := specGroups[groupname]
+		if !inSpec {
+			// a group only listed by users, it must exist already
+			current, cerr := lc.CurrentMembers(groupname)
+			if cerr != nil {
+				err = fmt.Errorf("group %s is not in the spec and does not exist", groupname)
+				return
+			}
+			currentMembers[groupname] = current
+			groupExists[groupname] = true
+		}
+		for _, username := range wanted[groupname] {
+			if userListed[username] || checked[username] {
+				continue
+			}
+			if missing := lc.MissingUsers([]string{username}); len(missing) > 0 {
+				err = fmt.Errorf("member %s of group %s is not in the spec and does not exist", username, groupname)
+				return
+			}
+			checked[username] = true
+		}
+		current := currentMembers[groupname]
+		var add, del []string
+		if inSpec && group.Members != nil {
+			add, del = diffNames(current, wanted[groupname])
+		} else {
+			add, _ = diffNames(current, wanted[groupname])
+		}
+		if len(add) == 0 && len(del) == 0 {
+			continue
+		}
+		changes := []string{}
+		for _, name := range add {
+			changes = append(changes, "+"+name)
+		}
+		for _, name := range del {
+			changes = append(changes, "-"+name)
+		}
+		groupname := groupname
+		plan = append(plan, PlanAction{Action: ActionUpdate, Kind: "group", Name: groupname, Changes: changes, apply: func() error {
+			return lc.applyMembers(groupname, add, del)
+		}})
+	}
+	return
+}
+
+// planPrune ... deletions of marked users and groups, or those below the prune base,
+// that are not in spec
+func (lc *LDAPClient) planPrune(spec DirectorySpec, markerAttr string, markerValue string, userListed map[string]bool) (plan []PlanAction, err error) {
+	p := lc.profile()
+	login := p.LoginAttr
+	marked := fmt.Sprintf("(%s=%s)", markerAttr, ldap.EscapeFilter(markerValue))
+
+	users, err := lc.pruneCandidates(p.UserFilter, marked, login, lc.PeopleDn(), spec.Prune.Base)
+	if err != nil {
+		return
+	}
+	pruned := map[string]bool{}
+	for _, entry := range users {
+		name := entry.Values(login)[0]
+		if userListed[name] {
+			continue
+		}
+		pruned[name] = true
+		dn := entry.DN
+		plan = append(plan, PlanAction{Action: ActionDelete, Kind: "user", Name: name, apply: func() error {
+			groups, err := lc.UserGroupNames(name)
+			if err != nil {
+				return err
+			}
+			for _, groupname := range groups {
+				if err = lc.applyMembers(groupname, nil, []string{name}); err != nil {
+					return err
+				}
+			}
+			return lc.Del(ldap.NewDelRequest(dn, nil))
+		}})
+	}
+
+	groupListed := map[string]bool{}
+	for _, group := range spec.Groups {
+		groupListed[group.Name] = true
+	}
+	groups, err := lc.pruneCandidates(p.GroupFilter, marked, "cn", lc.GroupDn(), spec.Prune.Base)
+	if err != nil {
+		return
+	}
+	for _, entry := range groups {
+		name := entry.Values("cn")[0]
+		if groupListed[name] {
+			continue
+		}
+		_, primaryUsers, uerr := lc.GroupUsage(name)
+		if uerr != nil {
+			err = uerr
+			return
+		}
+		for _, username := range primaryUsers {
+			if !pruned[username] {
+				err = fmt.Errorf("cannot prune group %s, it is the primary group of %s", name, username)
+				return
+			}
+		}
+		dn := entry.DN
+		plan = append(plan, PlanAction{Action: ActionDelete, Kind: "group", Name: name, apply: func() error {
+			return lc.Del(ldap.NewDelRequest(dn, nil))
+		}})
+	}
+	return
+}
+
+// pruneCandidates ... entries of filter carrying the marker below container,
+// and all entries of filter below base
+func (lc *LDAPClient) pruneCandidates(filter string, marked string, nameAttr string, container string, base string) (entries []LdapResult, err error) {
+	entries, err = lc.SearchEntries(fmt.Sprintf("(&%s%s)", filter, marked), []string{nameAttr}, container)
+	if err != nil || base == "" {
+		return
+	}
+	below, err := lc.SearchEntries(filter, []string{nameAttr}, base)
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		seen[strings.ToLower(entry.DN)] = true
+	}
+	for _, entry := range below {
+		if !seen[strings.ToLower(entry.DN)] && len(entry.Values(nameAttr)) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return
+}
+
+// ApplyPlan ... apply actions in order, stopping at the first failure
+func (lc *LDAPClient) ApplyPlan(plan []PlanAction) (err error) {
+	for _, action := range plan {
+		if err = lc.Transaction(action.apply); err != nil {
+			return fmt.Errorf("%s: %s", action.String(), err.Error())
+		}
+	}
+	return
+}
+
+// Apply ... print the plan of path and apply it if confirm agrees
+func Apply(lc *LDAPClient, path string, prune bool, confirm func(plan []PlanAction) bool) (err error) {
+	spec, err := ReadDirectorySpec(path)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return ApplySpec(lc, spec, ApplyOptions{Prune: prune}, confirm)
+}
+
+// ApplySpec ... print the plan of spec and apply it if confirm agrees
+func ApplySpec(lc *LDAPClient, spec DirectorySpec, opts ApplyOptions, confirm func(plan []PlanAction) bool) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	plan, err := lc.PlanApply(spec, opts)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	if len(plan) == 0 {
+		fmt.Println("nothing to do")
+		return
+	}
+	for _, action := range plan {
+		fmt.Println(action.String())
+	}
+	if !confirm(plan) {
+		fmt.Println("aborted")
+		return errors.New("aborted")
+	}
+	if err = lc.ApplyPlan(plan); err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
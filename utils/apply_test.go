@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readDirectorySpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "userctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "directory.yaml")
+	content := "users:\n" +
+		"  - name: alice\n" +
+		"    uidNumber: 2001\n" +
+		"    locked: true\n" +
+		"    groups: [dev]\n" +
+		"    attributes:\n" +
+		"      mail: alice@test.com\n" +
+		"      telephoneNumber: [\"1\", \"2\"]\n" +
+		"groups:\n" +
+		"  - name: dev\n" +
+		"    gidNumber: 5000\n" +
+		"  - name: empty\n" +
+		"    gidNumber: 5001\n" +
+		"    members: []\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ReadDirectorySpec(path)
+	if err != nil {
+		t.Fatalf("error reading spec: %v", err)
+	}
+	alice := spec.Users[0]
+	if alice.UIDNumber != "2001" || alice.Locked == nil || !*alice.Locked || alice.Groups[0] != "dev" {
+		t.Fatalf("unexpected user: %+v", alice)
+	}
+	if len(alice.Attributes["mail"]) != 1 || len(alice.Attributes["telephoneNumber"]) != 2 {
+		t.Fatalf("unexpected attributes: %v", alice.Attributes)
+	}
+	if spec.Groups[0].Members != nil || spec.Groups[1].Members == nil {
+		t.Fatalf("members of dev should be unset and of empty set: %+v", spec.Groups)
+	}
+	if attr, value, err := spec.marker(); err != nil || attr+"="+value != defaultMarker {
+		t.Fatalf("unexpected marker: %s=%s %v", attr, value, err)
+	}
+}
+
+// applyFixture ... posixFixture with the marked user bob and the marked,
+// unlisted group old
+func applyFixture() []LdapResult {
+	entries := posixFixture()
+	entries[4].Attributes["description"] = []string{"managed-by-userctl"}
+	return append(entries, fixture("cn=old,ou=Group,dc=test,dc=com", "objectClass", "posixGroup",
+		"cn", "old", "gidNumber", "400", "description", "managed-by-userctl"))
+}
+
+func applySpecFixture() DirectorySpec {
+	return DirectorySpec{
+		Users: []UserState{
+			{Name: "alice", Attributes: map[string]StringList{"mail": {"alice@test.com"}}},
+			{Name: "dave", UIDNumber: "1004", GIDNumber: "100", Groups: []string{"ops"}},
+		},
+		Groups: []GroupState{
+			{Name: "dev", GIDNumber: "200", Members: []string{"alice", "carol"}},
+			{Name: "ops", GIDNumber: "300"},
+		},
+	}
+}
+
+func planLines(plan []PlanAction) (lines []string) {
+	for _, action := range plan {
+		lines = append(lines, action.String())
+	}
+	return
+}
+
+func Test_planApply(t *testing.T) {
+	s := newFakeServer(t, applyFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	plan, err := lc.PlanApply(applySpecFixture(), ApplyOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"~ group dev (description)",
+		"+ group ops",
+		"~ user alice (mail, description)",
+		"+ user dave",
+		"~ group dev (+carol)",
+		"~ group ops (+dave)",
+		"- user bob",
+		"- group old",
+	}
+	if lines := planLines(plan); !reflect.DeepEqual(lines, want) {
+		t.Fatalf("unexpected plan:\n%s", strings.Join(lines, "\n"))
+	}
+
+	lc.DryRun = true
+	out := captureStdout(t, func() {
+		if err := lc.ApplyPlan(plan); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(s.Writes()) != 0 {
+		t.Fatalf("dry run wrote: %v", s.Writes())
+	}
+	if strings.Contains(out, "password of dave") {
+		t.Fatalf("dry run printed a password of a user it did not create:\n%s", out)
+	}
+
+	lc.DryRun = false
+	out = captureStdout(t, func() {
+		if err := lc.ApplyPlan(plan); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "password of dave: ") {
+		t.Fatalf("generated password not printed:\n%s", out)
+	}
+	if plan, err = lc.PlanApply(applySpecFixture(), ApplyOptions{Prune: true}); err != nil || len(plan) > 0 {
+		t.Fatalf("applied spec not in sync: %v %v", planLines(plan), err)
+	}
+	for _, dn := range []string{"uid=bob,ou=People,dc=test,dc=com", "cn=old,ou=Group,dc=test,dc=com"} {
+		if _, ok := s.entry(dn); ok {
+			t.Fatalf("%s not pruned", dn)
+		}
+	}
+}
+
+func Test_planMemberships(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	tests := []struct {
+		name string
+		spec DirectorySpec
+		want []string
+		err  string
+	}{
+		{
+			name: "members only added",
+			spec: DirectorySpec{Users: []UserState{{Name: "carol", Groups: []string{"dev"}}}},
+			want: []string{"~ group dev (+carol)"},
+		},
+		{
+			name: "exact members",
+			spec: DirectorySpec{Groups: []GroupState{{Name: "dev", Members: []string{"bob"}}}},
+			want: []string{"~ group dev (+bob, -alice)"},
+		},
+		{
+			name: "in sync",
+			spec: DirectorySpec{Users: []UserState{{Name: "alice", Groups: []string{"dev"}}}},
+		},
+		{
+			name: "unknown group",
+			spec: DirectorySpec{Users: []UserState{{Name: "alice", Groups: []string{"ops"}}}},
+			err:  "group ops is not in the spec and does not exist",
+		},
+		{
+			name: "unknown member",
+			spec: DirectorySpec{Groups: []GroupState{{Name: "dev", Members: []string{"erin"}}}},
+			err:  "member erin of group dev is not in the spec and does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userListed := map[string]bool{}
+			for _, user := range tt.spec.Users {
+				userListed[user.Name] = true
+			}
+			groupExists := map[string]bool{}
+			currentMembers := map[string][]string{}
+			for _, group := range tt.spec.Groups {
+				groupExists[group.Name] = true
+				current, err := lc.CurrentMembers(group.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				currentMembers[group.Name] = current
+			}
+			plan, err := lc.planMemberships(tt.spec, userListed, groupExists, currentMembers)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines := planLines(plan); !reflect.DeepEqual(lines, tt.want) {
+				t.Fatalf("unexpected plan: %v", lines)
+			}
+		})
+	}
+}
+
+func Test_planPrune(t *testing.T) {
+	entries := append(applyFixture(),
+		fixture("ou=Legacy,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("uid=erin,ou=Legacy,dc=test,dc=com", "objectClass", "posixAccount", "uid", "erin", "uidNumber", "1005", "gidNumber", "400"))
+	s := newFakeServer(t, entries...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	spec := DirectorySpec{}
+	plan, err := lc.planPrune(spec, "description", "managed-by-userctl", map[string]bool{"alice": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := planLines(plan); !reflect.DeepEqual(lines, []string{"- user bob", "- group old"}) {
+		t.Fatalf("unexpected plan: %v", lines)
+	}
+	if plan, err = lc.planPrune(spec, "description", "managed-by-userctl", map[string]bool{"bob": true}); err != nil || len(plan) != 1 {
+		t.Fatalf("listed user pruned: %v %v", planLines(plan), err)
+	}
+
+	// erin below the prune base is pruned without the marker
+	spec.Prune.Base = "ou=Legacy,dc=test,dc=com"
+	if plan, err = lc.planPrune(spec, "description", "managed-by-userctl", nil); err != nil {
+		t.Fatal(err)
+	}
+	if lines := planLines(plan); !reflect.DeepEqual(lines, []string{"- user bob", "- user erin", "- group old"}) {
+		t.Fatalf("unexpected plan: %v", lines)
+	}
+	if err = lc.ModifyUser("carol", map[string][]string{"gidNumber": {"400"}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = lc.planPrune(spec, "description", "managed-by-userctl", nil)
+	if err == nil || err.Error() != "cannot prune group old, it is the primary group of carol" {
+		t.Fatalf("primary group of a kept user pruned: %v", err)
+	}
+}
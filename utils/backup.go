@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Files of a backup archive
+const (
+	backupMetadataFile = "metadata.json"
+	backupEntriesFile  = "entries.ldif"
+)
+
+// Status of a restored entry
+const (
+	StatusRestored    = "restored"
+	StatusConflict    = "conflict"
+	StatusOverwritten = "overwritten"
+)
+
+// BackupMetadata ... where and when a backup was taken
+type BackupMetadata struct {
+	Server  string    `json:"server"`
+	BaseDn  string    `json:"baseDn"`
+	Profile string    `json:"profile"`
+	Created time.Time `json:"created"`
+	Bases   []string  `json:"bases"`
+	Users   int       `json:"users"`
+	Groups  int       `json:"groups"`
+}
+
+// Backup ... metadata and entries of a backup archive
+type Backup struct {
+	Metadata BackupMetadata
+	Entries  []LdapResult
+}
+
+// RestoreResult ... outcome of restoring one entry
+type RestoreResult struct {
+	DN         string          `json:"dn"`
+	Status     string          `json:"status"`
+	Attributes []AttributeDiff `json:"attributes,omitempty"`
+	Groups     []string        `json:"groups,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// BackupFileName ... userctl-<basedn>-<utc time>.tar.gz
+func BackupFileName(basedn string, t time.Time) string {
+	return fmt.Sprintf("userctl-%s-%s.tar.gz", unsafeFileChars.ReplaceAllString(basedn, "_"), t.UTC().Format("20060102T150405Z"))
+}
+
+// Backup ... users and groups with their containers
+func (lc *LDAPClient) Backup() (backup Backup, err error) {
+	bases := []string{lc.PeopleDn(), lc.GroupDn()}
+	if bases[0] == bases[1] {
+		bases = bases[:1]
+	}
+	entries, err := lc.ExportEntries(bases, "(objectClass=*)")
+	if err != nil {
+		return
+	}
+	snapshot := newSnapshot("", "")
+	for _, entry := range entries {
+		snapshot.add(entry, lc.profile())
+	}
+	backup = Backup{
+		Metadata: BackupMetadata{
+			Server:  lc.Addr,
+			BaseDn:  lc.BaseDn,
+			Profile: lc.profile().Name,
+			Created: time.Now().UTC(),
+			Bases:   bases,
+			Users:   len(snapshot.Users),
+			Groups:  len(snapshot.Groups),
+		},
+		Entries: entries,
+	}
+	return
+}
+
+// Write ... write backup as a gzip compressed tar of metadata.json and entries.ldif
+func (b Backup) Write(w io.Writer) (err error) {
+	metadata, err := json.MarshalIndent(b.Metadata, "", "  ")
+	if err != nil {
+		return
+	}
+	var ldif bytes.Buffer
+	ldif.WriteString("version: 1\n\n")
+	if err = WriteLDIF(&ldif, b.Entries); err != nil {
+		return
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{backupMetadataFile, metadata},
+		{backupEntriesFile, ldif.Bytes()},
+	} {
+		header := &tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.content)), ModTime: b.Metadata.Created}
+		if err = tw.WriteHeader(header); err != nil {
+			return
+		}
+		if _, err = tw.Write(file.content); err != nil {
+			return
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return
+	}
+	return gz.Close()
+}
+
+// ReadBackup ... read a backup archive
+func ReadBackup(r io.Reader) (backup Backup, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	found := map[string]bool{}
+	for {
+		header, herr := tr.Next()
+		if herr == io.EOF {
+			break
+		}
+		if herr != nil {
+			err = herr
+			return
+		}
+		switch header.Name {
+		case backupMetadataFile:
+			content, rerr := ioutil.ReadAll(tr)
+			if rerr != nil {
+				err = rerr
+				return
+			}
+			if err = json.Unmarshal(content, &backup.Metadata); err != nil {
+				return
+			}
+		case backupEntriesFile:
+			records, rerr := ReadLDIF(tr)
+			if rerr != nil {
+				err = rerr
+				return
+			}
+			for _, record := range records {
+				backup.Entries = append(backup.Entries, LdapResult{DN: record.DN, Attributes: record.Attributes})
+			}
+		default:
+			continue
+		}
+		found[header.Name] = true
+	}
+	if !found[backupMetadataFile] || !found[backupEntriesFile] {
+		err = errors.New("not a userctl backup")
+	}
+	return
+}
+
+// selectEntries ... entries of the users or groups named, all entries if kind is empty
+func selectEntries(snapshot Snapshot, entries []LdapResult, kind string, names []string) (selected []LdapResult, err error) {
+	var byName map[string]LdapResult
+	switch kind {
+	case "":
+		return entries, nil
+	case SidUser:
+		byName = snapshot.Users
+	case SidGroup:
+		byName = snapshot.Groups
+	default:
+		err = fmt.Errorf("unknown kind %s, user or group", kind)
+		return
+	}
+	for _, name := range names {
+		entry, ok := byName[name]
+		if !ok {
+			err = fmt.Errorf("%s %s is not in the snapshot", kind, name)
+			return
+		}
+		selected = append(selected, entry)
+	}
+	return
+}
+
+// restoreEntry ... add entry, or compare it with the current one and with
+// overwrite replace what differs
+func (lc *LDAPClient) restoreEntry(entry LdapResult, overwrite bool) (result RestoreResult) {
+	result.DN = entry.DN
+	current, err := lc.ReadEntry(entry.DN, []string{})
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		result.Status, result.Error = StatusFailed, err.Error()
+		return
+	}
+	if err != nil || len(current.Attributes) == 0 {
+		addrequest := ldap.NewAddRequest(entry.DN)
+		for _, name := range sortedAttrNames(entry.Attributes) {
+			addrequest.Attribute(name, entry.Attributes[name])
+		}
+		if err = lc.Add(addrequest); err != nil {
+			result.Status, result.Error = StatusFailed, err.Error()
+			return
+		}
+		result.Status = StatusRestored
+		return
+	}
+	ignore := map[string]bool{}
+	for _, attr := range DefaultDiffIgnore {
+		ignore[strings.ToLower(attr)] = true
+	}
+	result.Attributes = diffEntry(entry, "", current, "", ignore)
+	if len(result.Attributes) == 0 {
+		result.Status = StatusUnchanged
+		return
+	}
+	if !overwrite {
+		result.Status = StatusConflict
+		return
+	}
+	modify := ldap.NewModifyRequest(entry.DN)
+	for _, attr := range result.Attributes {
+		modify.Replace(attr.Name, entry.Values(attr.Name))
+	}
+	if err = lc.Modify(modify); err != nil {
+		result.Status, result.Error = StatusFailed, err.Error()
+		return
+	}
+	result.Status = StatusOverwritten
+	return
+}
+
+// restoreMemberships ... add username back to the groups that listed it in the backup
+func (lc *LDAPClient) restoreMemberships(snapshot Snapshot, username string) (groups []string, err error) {
+	for groupname, group := range snapshot.Groups {
+		names, nerr := lc.memberNames(group)
+		if nerr != nil {
+			err = nerr
+			return
+		}
+		if !containsName(names, username) {
+			continue
+		}
+		current, cerr := lc.CurrentMembers(groupname)
+		if cerr != nil || containsName(current, username) {
+			continue
+		}
+		if err = lc.applyMembers(groupname, []string{username}, nil); err != nil {
+			return
+		}
+		groups = append(groups, groupname)
+	}
+	sort.Strings(groups)
+	return
+}
+
+// Restore ... restore all entries of backup, parents first, or the users or
+// groups named. Restored users rejoin the groups they were members of.
+func (lc *LDAPClient) Restore(backup Backup, kind string, names []string, overwrite bool) (results []RestoreResult, err error) {
+	if !strings.EqualFold(backup.Metadata.BaseDn, lc.BaseDn) {
+		err = fmt.Errorf("snapshot is of %s, not %s", backup.Metadata.BaseDn, lc.BaseDn)
+		return
+	}
+	snapshot := newSnapshot("", "")
+	for _, entry := range backup.Entries {
+		snapshot.add(entry, lc.profile())
+	}
+	selected, err := selectEntries(snapshot, backup.Entries, kind, names)
+	if err != nil {
+		return
+	}
+	for i, entry := range selected {
+		var result RestoreResult
+		// a restored user whose groups cannot be rejoined is removed again
+		terr := lc.Transaction(func() (err error) {
+			result = lc.restoreEntry(entry, overwrite)
+			if kind == SidUser && result.Status == StatusRestored {
+				result.Groups, err = lc.restoreMemberships(snapshot, names[i])
+			}
+			return
+		})
+		if terr != nil {
+			result.Status, result.Error, result.Groups = StatusFailed, terr.Error(), nil
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// BackupTo ... write a backup archive into dir, or to path if it is not a directory
+func BackupTo(lc *LDAPClient, path string) (file string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	backup, err := lc.Backup()
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	file = path
+	if fi, serr := os.Stat(path); serr == nil && fi.IsDir() {
+		file = filepath.Join(path, BackupFileName(lc.BaseDn, backup.Metadata.Created))
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	defer f.Close()
+	if err = backup.Write(f); err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
+
+// RestoreFrom ... restore entries of the backup archive at path
+func RestoreFrom(lc *LDAPClient, path string, kind string, names []string, overwrite bool) (data string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	defer f.Close()
+	backup, err := ReadBackup(f)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	results, err := lc.Restore(backup, kind, names, overwrite)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	resultsbytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(resultsbytes)
+	for _, result := range results {
+		if result.Status == StatusFailed || result.Status == StatusConflict {
+			err = errors.New("some entries were not restored")
+		}
+	}
+	return
+}
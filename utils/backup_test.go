@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_backupRoundTrip(t *testing.T) {
+	created := time.Date(2018, 5, 1, 12, 30, 0, 0, time.UTC)
+	backup := Backup{
+		Metadata: BackupMetadata{Server: "ldap://localhost:389", BaseDn: "dc=test,dc=com", Profile: "rfc2307", Created: created, Users: 1},
+		Entries: []LdapResult{
+			{DN: "ou=People,dc=test,dc=com", Attributes: map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"People"}}},
+			{DN: "uid=alice,ou=People,dc=test,dc=com", Attributes: map[string][]string{"objectClass": {"posixAccount"}, "uid": {"alice"}}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := backup.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Metadata.BaseDn != "dc=test,dc=com" || !read.Metadata.Created.Equal(created) || read.Metadata.Users != 1 {
+		t.Fatalf("unexpected metadata: %+v", read.Metadata)
+	}
+	if len(read.Entries) != 2 || read.Entries[1].DN != "uid=alice,ou=People,dc=test,dc=com" || read.Entries[1].Values("uid")[0] != "alice" {
+		t.Fatalf("unexpected entries: %+v", read.Entries)
+	}
+
+	if _, err := ReadBackup(bytes.NewBufferString("not gzip")); err == nil {
+		t.Fatal("expected error reading a non backup")
+	}
+	if name := BackupFileName("dc=test,dc=com", created); name != "userctl-dc_test_dc_com-20180501T123000Z.tar.gz" {
+		t.Fatalf("unexpected file name %s", name)
+	}
+}
+
+func Test_restore(t *testing.T) {
+	entries := posixFixture()
+	entries[3].Attributes["mail"] = []string{"alice@new.com"}
+	entries[3].Attributes["description"] = []string{"added after the backup"}
+	s := newFakeServer(t, entries...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	backup := Backup{
+		Metadata: BackupMetadata{BaseDn: "dc=test,dc=com"},
+		Entries: []LdapResult{
+			fixture("ou=People,dc=test,dc=com", "objectClass", "organizationalUnit"),
+			fixture("uid=alice,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "alice", "uidNumber", "1001", "gidNumber", "100",
+				"mail", "alice@test.com", "telephoneNumber", "1"),
+			fixture("uid=dave,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "dave", "uidNumber", "1004", "gidNumber", "100"),
+		},
+	}
+	results, err := lc.Restore(backup, "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	if !reflect.DeepEqual(statuses, []string{StatusUnchanged, StatusConflict, StatusRestored}) {
+		t.Fatalf("unexpected statuses: %v", statuses)
+	}
+	var conflicts []string
+	for _, attr := range results[1].Attributes {
+		conflicts = append(conflicts, attr.Name)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"description", "mail", "telephoneNumber"}) {
+		t.Fatalf("unexpected conflicting attributes: %v", conflicts)
+	}
+	if writes := s.Writes(); !reflect.DeepEqual(writes, []string{"add uid=dave,ou=People,dc=test,dc=com"}) {
+		t.Fatalf("conflict written without overwrite: %v", writes)
+	}
+
+	results, err = lc.Restore(backup, SidUser, []string{"alice"}, true)
+	if err != nil || len(results) != 1 || results[0].Status != StatusOverwritten {
+		t.Fatalf("unexpected overwrite: %+v %v", results, err)
+	}
+	alice, _ := s.entry("uid=alice,ou=People,dc=test,dc=com")
+	if !reflect.DeepEqual(alice.Values("mail"), []string{"alice@test.com"}) ||
+		!reflect.DeepEqual(alice.Values("telephoneNumber"), []string{"1"}) || len(alice.Values("description")) > 0 {
+		t.Fatalf("attributes not replaced by the snapshot's: %v", alice.Attributes)
+	}
+}
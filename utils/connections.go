@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Connection ... a named directory in the connections file
+type Connection struct {
+	URL      string `yaml:"url"`
+	WriteURL string `yaml:"writeUrl"`
+	Balance  string `yaml:"balance"`
+	BaseDn   string `yaml:"baseDn"`
+	Admin    string `yaml:"admin"`
+	AdminPw  string `yaml:"adminPw"`
+	Profile  string `yaml:"profile"`
+	TLS      bool   `yaml:"tls"`
+	StartTLS bool   `yaml:"startTLS"`
+}
+
+// DefaultConnectionsFile ... ~/.userctl.yaml
+func DefaultConnectionsFile() string {
+	return filepath.Join(os.Getenv("HOME"), ".userctl.yaml")
+}
+
+// LookupConnection ... connection name of the connections file at path
+func LookupConnection(path string, name string) (conn Connection, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	connections := map[string]Connection{}
+	if err = yaml.UnmarshalStrict(content, &connections); err != nil {
+		return
+	}
+	conn, ok := connections[name]
+	if !ok {
+		err = fmt.Errorf("connection %s not found in %s", name, path)
+	}
+	return
+}
+
+// Apply ... set the client fields given in conn
+func (conn Connection) Apply(lc *LDAPClient) (err error) {
+	if conn.URL != "" {
+		lc.Addr = conn.URL
+		lc.WriteAddr = conn.WriteURL
+	}
+	if conn.Balance != "" {
+		lc.Balance = conn.Balance
+	}
+	if conn.BaseDn != "" {
+		lc.BaseDn = conn.BaseDn
+	}
+	if conn.Admin != "" {
+		lc.BindDn = conn.Admin
+	}
+	if conn.AdminPw != "" {
+		lc.BindPass = conn.AdminPw
+	}
+	if conn.Profile != "" {
+		if lc.Profile, err = LookupProfile(conn.Profile); err != nil {
+			return
+		}
+	}
+	lc.TLS = conn.TLS
+	lc.StartTLS = conn.StartTLS
+	return
+}
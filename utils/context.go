@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// WithContext ... copy of lc whose later connections give up and are closed
+// when ctx is done, like http.Request.WithContext
+func (lc *LDAPClient) WithContext(ctx context.Context) *LDAPClient {
+	client := *lc
+	client.ctx = ctx
+	client.unwatch = nil
+	return &client
+}
+
+// Context ... context of lc, background if none was set
+func (lc *LDAPClient) Context() context.Context {
+	if lc.ctx == nil {
+		return context.Background()
+	}
+	return lc.ctx
+}
+
+// contextError ... the reason ctx is done instead of the network error it caused
+func contextError(ctx context.Context, err error) error {
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// dial ... open and start a connection to server, the tls handshake
+// included, within lc.Timeout and before ctx is done
+func (lc *LDAPClient) dial(ctx context.Context, server serverURL) (conn *ldap.Conn, err error) {
+	dialer := net.Dialer{Timeout: lc.Timeout}
+	c, err := dialer.DialContext(ctx, server.Network, server.Address)
+	if err != nil {
+		return nil, contextError(ctx, ldap.NewError(ldap.ErrorNetwork, err))
+	}
+	if server.TLS {
+		tc := tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+		if lc.Timeout > 0 {
+			tc.SetDeadline(time.Now().Add(lc.Timeout))
+		}
+		if err = tc.Handshake(); err != nil {
+			c.Close()
+			return nil, contextError(ctx, ldap.NewError(ldap.ErrorNetwork, err))
+		}
+		tc.SetDeadline(time.Time{})
+		c = tc
+	}
+	conn = ldap.NewConn(c, server.TLS)
+	conn.Start()
+	conn.SetTimeout(lc.Timeout)
+	return
+}
+
+// watch ... close conn when ctx is done, failing its outstanding requests.
+// ldap.v2 cannot send abandon requests, closing the connection makes the
+// server abandon them instead
+func (lc *LDAPClient) watch(ctx context.Context, conn *ldap.Conn) {
+	if ctx.Done() == nil {
+		return
+	}
+	if lc.unwatch == nil {
+		lc.unwatch = make(chan struct{})
+	}
+	unwatch := lc.unwatch
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-unwatch:
+		}
+	}()
+}
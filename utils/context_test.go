@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// silentServer ... accepts connections and never answers
+func silentServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var conns []net.Conn
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, c)
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	return l
+}
+
+func Test_connectContext(t *testing.T) {
+	l := silentServer(t)
+	defer l.Close()
+
+	lc := &LDAPClient{Addr: l.Addr().String(), Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if err := lc.Connect(); err == nil {
+		t.Fatal("bind to a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("timeout not applied, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	lc = (&LDAPClient{Addr: l.Addr().String()}).WithContext(ctx)
+	if err := lc.Connect(); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	lc.Close()
+}
@@ -0,0 +1,319 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Status of an entry in a diff
+const (
+	DiffMissing = "missing"
+	DiffExtra   = "extra"
+	DiffDiffers = "differs"
+)
+
+// baseToken ... replaces the basedn in values so directories with different bases compare
+const baseToken = "<base>"
+
+// DefaultDiffIgnore ... operational and per server attributes not compared
+var DefaultDiffIgnore = []string{
+	"createTimestamp", "modifyTimestamp", "creatorsName", "modifiersName",
+	"entryUUID", "entryCSN", "entryDN", "structuralObjectClass",
+	"subschemaSubentry", "hasSubordinates", "contextCSN", "pwdChangedTime",
+	"sambaPwdLastSet", "sambaPasswordHistory", "sambaBadPasswordCount",
+	"sambaBadPasswordTime", "sambaLogonTime", "sambaLogoffTime",
+	"whenCreated", "whenChanged", "uSNCreated", "uSNChanged", "objectGUID",
+	"lastLogon", "lastLogonTimestamp", "logonCount", "badPwdCount",
+	"badPasswordTime", "pwdLastSet", "dSCorePropagationData", "instanceType",
+	"nsUniqueId", "ipaUniqueID",
+}
+
+// userObjectClasses and groupObjectClasses ... classify snapshot entries
+var (
+	userObjectClasses  = []string{"posixAccount", "sambaSamAccount", "inetOrgPerson", "user"}
+	groupObjectClasses = []string{"posixGroup", "sambaGroupMapping", "groupOfNames", "groupOfUniqueNames", "group"}
+)
+
+// Snapshot ... users and groups of a directory or a file, by name
+type Snapshot struct {
+	Source string
+	// BaseDn is replaced by <base> in values when comparing
+	BaseDn string
+	Users  map[string]LdapResult
+	Groups map[string]LdapResult
+}
+
+// AttributeDiff ... values only in the from or only in the to entry
+type AttributeDiff struct {
+	Name    string   `json:"name"`
+	Missing []string `json:"missing,omitempty"`
+	Extra   []string `json:"extra,omitempty"`
+}
+
+// DiffEntry ... a user or group missing from to, extra in to, or differing
+type DiffEntry struct {
+	Kind       string          `json:"kind"`
+	Name       string          `json:"name"`
+	Status     string          `json:"status"`
+	Attributes []AttributeDiff `json:"attributes,omitempty"`
+}
+
+func newSnapshot(source string, basedn string) Snapshot {
+	return Snapshot{Source: source, BaseDn: basedn, Users: map[string]LdapResult{}, Groups: map[string]LdapResult{}}
+}
+
+// Snapshot ... all users and groups of the directory
+func (lc *LDAPClient) Snapshot() (snapshot Snapshot, err error) {
+	p := lc.profile()
+	snapshot = newSnapshot(lc.Addr, lc.BaseDn)
+	users, err := lc.SearchEntries(p.UserFilter, []string{}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	for _, entry := range users {
+		if name := entry.Values(p.LoginAttr); len(name) > 0 {
+			snapshot.Users[name[0]] = entry
+		}
+	}
+	groups, err := lc.SearchEntries(p.GroupFilter, []string{}, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	for _, entry := range groups {
+		if name := entry.Values("cn"); len(name) > 0 {
+			snapshot.Groups[name[0]] = entry
+		}
+	}
+	return
+}
+
+// add ... file entry as user or group, by its objectClass
+func (s *Snapshot) add(entry LdapResult, p *Profile) {
+	first := func(attrs ...string) string {
+		for _, attr := range attrs {
+			if v := entry.Values(attr); len(v) > 0 {
+				return v[0]
+			}
+		}
+		return ""
+	}
+	for _, class := range groupObjectClasses {
+		if hasObjectClass(entry, class) {
+			if name := first("cn"); name != "" {
+				s.Groups[name] = entry
+			}
+			return
+		}
+	}
+	for _, class := range userObjectClasses {
+		if hasObjectClass(entry, class) {
+			if name := first(p.LoginAttr, "uid"); name != "" {
+				s.Users[name] = entry
+			}
+			return
+		}
+	}
+}
+
+// baseOf ... basedn of dn below the container rdn, e.g. ou=People
+func baseOf(dn string, rdn string) string {
+	i := strings.Index(strings.ToLower(dn), ","+strings.ToLower(rdn)+",")
+	if i < 0 {
+		return ""
+	}
+	return dn[i+len(rdn)+2:]
+}
+
+// ReadSnapshot ... users and groups of an LDIF export or a json array of entries
+func ReadSnapshot(path string, p *Profile) (snapshot Snapshot, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var entries []LdapResult
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&entries)
+	default:
+		records, rerr := ReadLDIF(f)
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		for _, record := range records {
+			if record.ChangeType == ChangeAdd {
+				entries = append(entries, LdapResult{DN: record.DN, Attributes: record.Attributes})
+			}
+		}
+	}
+	if err != nil {
+		return
+	}
+	snapshot = newSnapshot(path, "")
+	for _, entry := range entries {
+		snapshot.add(entry, p)
+		if snapshot.BaseDn == "" {
+			if snapshot.BaseDn = baseOf(entry.DN, p.PeopleRdn); snapshot.BaseDn == "" {
+				snapshot.BaseDn = baseOf(entry.DN, p.GroupRdn)
+			}
+		}
+	}
+	return
+}
+
+// normalizedValues ... attributes by lower case name, with basedn replaced
+func normalizedValues(entry LdapResult, basedn string, ignore map[string]bool) (names map[string]string, values map[string][]string) {
+	names = map[string]string{}
+	values = map[string][]string{}
+	lowerBase := strings.ToLower(basedn)
+	for name, vals := range entry.Attributes {
+		key := strings.ToLower(name)
+		if ignore[key] {
+			continue
+		}
+		names[key] = name
+		for _, v := range vals {
+			if lowerBase != "" && strings.HasSuffix(strings.ToLower(v), lowerBase) {
+				v = v[:len(v)-len(lowerBase)] + baseToken
+			}
+			values[key] = append(values[key], v)
+		}
+	}
+	return
+}
+
+// diffEntry ... attribute differences of two entries
+func diffEntry(from LdapResult, fromBase string, to LdapResult, toBase string, ignore map[string]bool) (diffs []AttributeDiff) {
+	fromNames, fromValues := normalizedValues(from, fromBase, ignore)
+	toNames, toValues := normalizedValues(to, toBase, ignore)
+	keys := map[string]string{}
+	for key, name := range toNames {
+		keys[key] = name
+	}
+	for key, name := range fromNames {
+		keys[key] = name
+	}
+	sorted := []string{}
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		missing, extra := diffNames(toValues[key], fromValues[key])
+		if len(missing) == 0 && len(extra) == 0 {
+			continue
+		}
+		diff := AttributeDiff{Name: keys[key], Missing: redact(key, missing), Extra: redact(key, extra)}
+		diffs = append(diffs, diff)
+	}
+	return
+}
+
+// diffKind ... entries of one kind missing from to, extra in to or differing
+func diffKind(kind string, from map[string]LdapResult, fromBase string, to map[string]LdapResult, toBase string, ignore map[string]bool) (entries []DiffEntry) {
+	names := []string{}
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fromEntry, inFrom := from[name]
+		toEntry, inTo := to[name]
+		switch {
+		case !inTo:
+			entries = append(entries, DiffEntry{Kind: kind, Name: name, Status: DiffMissing})
+		case !inFrom:
+			entries = append(entries, DiffEntry{Kind: kind, Name: name, Status: DiffExtra})
+		default:
+			if attrs := diffEntry(fromEntry, fromBase, toEntry, toBase, ignore); len(attrs) > 0 {
+				entries = append(entries, DiffEntry{Kind: kind, Name: name, Status: DiffDiffers, Attributes: attrs})
+			}
+		}
+	}
+	return
+}
+
+// DiffSnapshots ... users and groups missing from to, extra in to, or differing by attribute
+func DiffSnapshots(from Snapshot, to Snapshot, ignore []string) (entries []DiffEntry) {
+	ignored := map[string]bool{}
+	for _, attr := range ignore {
+		ignored[strings.ToLower(attr)] = true
+	}
+	entries = diffKind("user", from.Users, from.BaseDn, to.Users, to.BaseDn, ignored)
+	return append(entries, diffKind("group", from.Groups, from.BaseDn, to.Groups, to.BaseDn, ignored)...)
+}
+
+// FormatDiff ... diff entries as text, - missing from to, + extra in to, ~ differing
+func FormatDiff(entries []DiffEntry) string {
+	var b strings.Builder
+	sign := map[string]string{DiffMissing: "-", DiffExtra: "+", DiffDiffers: "~"}
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s %s %s\n", sign[entry.Status], entry.Kind, entry.Name)
+		for _, attr := range entry.Attributes {
+			for _, v := range attr.Missing {
+				fmt.Fprintf(&b, "    - %s: %s\n", attr.Name, v)
+			}
+			for _, v := range attr.Extra {
+				fmt.Fprintf(&b, "    + %s: %s\n", attr.Name, v)
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// DiffSource ... a directory or a snapshot file
+type DiffSource struct {
+	Client *LDAPClient
+	Path   string
+}
+
+// Snapshot ... read the file, or connect and read the directory
+func (s DiffSource) Snapshot() (snapshot Snapshot, err error) {
+	if s.Path != "" {
+		return ReadSnapshot(s.Path, s.Client.profile())
+	}
+	if err = s.Client.Connect(); err != nil {
+		return
+	}
+	defer s.Client.Close()
+	return s.Client.Snapshot()
+}
+
+// Diff ... compare two directories or snapshots
+func Diff(from DiffSource, to DiffSource, ignore []string, asJSON bool) (data string, entries []DiffEntry, err error) {
+	fromSnapshot, err := from.Snapshot()
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	toSnapshot, err := to.Snapshot()
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	entries = DiffSnapshots(fromSnapshot, toSnapshot, ignore)
+	if !asJSON {
+		return FormatDiff(entries), entries, nil
+	}
+	if entries == nil {
+		entries = []DiffEntry{}
+	}
+	entriesbytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(entriesbytes)
+	return
+}
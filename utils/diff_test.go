@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func Test_diffSnapshots(t *testing.T) {
+	from := newSnapshot("prod", "dc=prod,dc=com")
+	to := newSnapshot("staging", "dc=staging,dc=com")
+	from.Users["alice"] = LdapResult{Attributes: map[string][]string{
+		"mail":            {"alice@test.com"},
+		"sambaPwdLastSet": {"1"},
+	}}
+	to.Users["alice"] = LdapResult{Attributes: map[string][]string{
+		"Mail":            {"alice@staging.com"},
+		"sambaPwdLastSet": {"2"},
+	}}
+	from.Users["bob"] = LdapResult{}
+	from.Groups["dev"] = LdapResult{Attributes: map[string][]string{"member": {"uid=alice,ou=People,dc=prod,dc=com"}}}
+	to.Groups["dev"] = LdapResult{Attributes: map[string][]string{"member": {"uid=alice,ou=People,dc=staging,dc=com"}}}
+	to.Groups["ops"] = LdapResult{}
+
+	entries := DiffSnapshots(from, to, DefaultDiffIgnore)
+	if len(entries) != 3 {
+		t.Fatalf("unexpected diff: %+v", entries)
+	}
+	alice := entries[0]
+	if alice.Name != "alice" || alice.Status != DiffDiffers || len(alice.Attributes) != 1 {
+		t.Fatalf("unexpected alice: %+v", alice)
+	}
+	if attr := alice.Attributes[0]; attr.Missing[0] != "alice@test.com" || attr.Extra[0] != "alice@staging.com" {
+		t.Fatalf("unexpected mail diff: %+v", attr)
+	}
+	if entries[1].Name != "bob" || entries[1].Status != DiffMissing {
+		t.Fatalf("unexpected bob: %+v", entries[1])
+	}
+	if entries[2].Kind != "group" || entries[2].Name != "ops" || entries[2].Status != DiffExtra {
+		t.Fatalf("unexpected ops: %+v", entries[2])
+	}
+}
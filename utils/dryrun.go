@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// secretAttrs ... attributes whose values are never printed
+var secretAttrs = map[string]bool{
+	"userpassword":    true,
+	"sambantpassword": true,
+	"sambalmpassword": true,
+	"unicodepwd":      true,
+}
+
+// redacted ... stands in for secret values, LDIF safe so it is printed as is
+// rather than base64 encoded like a value starting with <
+const redacted = "(redacted)"
+
+func redact(attr string, values []string) []string {
+	if !secretAttrs[strings.ToLower(attr)] {
+		return values
+	}
+	out := make([]string, len(values))
+	for i := range values {
+		out[i] = redacted
+	}
+	return out
+}
+
+// redactEntry ... copy of entry with secrets redacted
+func redactEntry(entry LdapResult) LdapResult {
+	out := LdapResult{DN: entry.DN, Attributes: map[string][]string{}}
+	for name, values := range entry.Attributes {
+		out.Attributes[name] = redact(name, values)
+	}
+	return out
+}
+
+// printChange ... print a change record as LDIF, with secrets redacted
+func printChange(dn string, changetype string, body func(w *bufio.Writer)) {
+	w := bufio.NewWriter(os.Stdout)
+	writeLDIFLine(w, "dn", dn)
+	writeLDIFLine(w, "changetype", changetype)
+	if body != nil {
+		body(w)
+	}
+	w.WriteString("\n")
+	w.Flush()
+}
+
+// Add ... send add request, or print it in dry run mode. Inside a
+// Transaction each change is journaled with its inverse, see Journal
+func (lc *LDAPClient) Add(addrequest *ldap.AddRequest) error {
+	if !lc.DryRun {
+		conn, err := lc.writer()
+		if err != nil {
+			return err
+		}
+		if err = conn.Add(addrequest); err != nil {
+			return err
+		}
+		if lc.journal != nil {
+			lc.journalAdd(addrequest.DN)
+		}
+		return nil
+	}
+	printChange(addrequest.DN, "add", func(w *bufio.Writer) {
+		for _, attr := range addrequest.Attributes {
+			for _, v := range redact(attr.Type, attr.Vals) {
+				writeLDIFLine(w, attr.Type, v)
+			}
+		}
+	})
+	return nil
+}
+
+// Modify ... send modify request, or print it in dry run mode
+func (lc *LDAPClient) Modify(modify *ldap.ModifyRequest) error {
+	if !lc.DryRun {
+		conn, err := lc.writer()
+		if err != nil {
+			return err
+		}
+		record := func() {}
+		if lc.journal != nil {
+			if record, err = lc.journalModify(conn, modify.DN, modifiedAttrs(modify)); err != nil {
+				return err
+			}
+		}
+		if err = conn.Modify(modify); err != nil {
+			return err
+		}
+		record()
+		return nil
+	}
+	printChange(modify.DN, "modify", func(w *bufio.Writer) {
+		ops := []struct {
+			name  string
+			attrs []ldap.PartialAttribute
+		}{
+			{"add", modify.AddAttributes},
+			{"delete", modify.DeleteAttributes},
+			{"replace", modify.ReplaceAttributes},
+		}
+		for _, op := range ops {
+			for _, attr := range op.attrs {
+				writeLDIFLine(w, op.name, attr.Type)
+				for _, v := range redact(attr.Type, attr.Vals) {
+					writeLDIFLine(w, attr.Type, v)
+				}
+				w.WriteString("-\n")
+			}
+		}
+	})
+	return nil
+}
+
+// Del ... send delete request, or print it in dry run mode
+func (lc *LDAPClient) Del(delrequest *ldap.DelRequest) error {
+	if !lc.DryRun {
+		conn, err := lc.writer()
+		if err != nil {
+			return err
+		}
+		record := func() {}
+		if lc.journal != nil {
+			if record, err = lc.journalDel(conn, delrequest.DN); err != nil {
+				return err
+			}
+		}
+		if err = conn.Del(delrequest); err != nil {
+			return err
+		}
+		record()
+		return nil
+	}
+	printChange(delrequest.DN, "delete", nil)
+	return nil
+}
+
+// ModifyDN ... rename or move the leaf entry dn, or print it in dry run mode.
+// ldap.v2 has no ModifyDN request, so the entry is copied and the original deleted
+func (lc *LDAPClient) ModifyDN(dn string, newRDN string, deleteOldRDN bool, newSuperior string) error {
+	if !lc.DryRun {
+		return lc.Transaction(func() error {
+			return lc.moveEntry(dn, newRDN, deleteOldRDN, newSuperior)
+		})
+	}
+	printChange(dn, "modrdn", func(w *bufio.Writer) {
+		writeLDIFLine(w, "newrdn", newRDN)
+		if deleteOldRDN {
+			writeLDIFLine(w, "deleteoldrdn", "1")
+		} else {
+			writeLDIFLine(w, "deleteoldrdn", "0")
+		}
+		if newSuperior != "" {
+			writeLDIFLine(w, "newsuperior", newSuperior)
+		}
+	})
+	return nil
+}
+
+// PasswordModify ... send password modify request, or print it in dry run mode
+func (lc *LDAPClient) PasswordModify(passwordModifyRequest *ldap.PasswordModifyRequest) error {
+	if !lc.DryRun {
+		conn, err := lc.writer()
+		if err != nil {
+			return err
+		}
+		record := func() {}
+		if lc.journal != nil {
+			if record, err = lc.journalModify(conn, passwordModifyRequest.UserIdentity, []string{"userPassword"}); err != nil {
+				return err
+			}
+		}
+		if _, err = conn.PasswordModify(passwordModifyRequest); err != nil {
+			return err
+		}
+		record()
+		return nil
+	}
+	w := bufio.NewWriter(os.Stdout)
+	w.WriteString("# password modify extended operation (1.3.6.1.4.1.4203.1.11.1)\n")
+	writeLDIFLine(w, "# userIdentity", passwordModifyRequest.UserIdentity)
+	writeLDIFLine(w, "# newPassword", redacted)
+	w.WriteString("\n")
+	return w.Flush()
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// captureStdout ... what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		done <- out
+	}()
+	fn()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-done)
+}
+
+var secretValues = map[string]string{
+	"userPassword":    "{SSHA}secrethash",
+	"sambaNTPassword": "0123456789ABCDEF0123456789ABCDEF",
+	"unicodePwd":      "\"secret\"",
+}
+
+func Test_dryRunRedacts(t *testing.T) {
+	entry := fixture("uid=carol,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "carol")
+	for attr, value := range secretValues {
+		entry.Attributes[attr] = []string{value}
+	}
+	s := newFakeServer(t, append(posixFixture()[:3], entry)...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	lc.DryRun = true
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	dir, err := ioutil.TempDir("", "userctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "deleted.ldif")
+
+	out := captureStdout(t, func() {
+		if err := lc.ArchiveUser("carol", archive); err != nil {
+			t.Fatal(err)
+		}
+		addrequest := ldap.NewAddRequest(entry.DN)
+		modify := ldap.NewModifyRequest(entry.DN)
+		for _, attr := range sortedAttrNames(entry.Attributes) {
+			addrequest.Attribute(attr, entry.Attributes[attr])
+			modify.Replace(attr, entry.Attributes[attr])
+		}
+		lc.Add(addrequest)
+		lc.Modify(modify)
+	})
+	if _, err = os.Stat(archive); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote the archive: %v", err)
+	}
+	if !strings.Contains(out, "# archive to "+archive) || strings.Count(out, "uid: carol") != 3 {
+		t.Fatalf("entry not printed:\n%s", out)
+	}
+	for attr, value := range secretValues {
+		if strings.Contains(out, value) || strings.Count(out, attr+": "+redacted) != 3 {
+			t.Fatalf("%s not redacted:\n%s", attr, out)
+		}
+	}
+}
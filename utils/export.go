@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// splitDN ... first rdn and parent of dn, honouring escaped commas
+func splitDN(dn string) (rdn string, parent string) {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return dn[:i], strings.TrimLeft(dn[i+1:], " ")
+		}
+	}
+	return dn, ""
+}
+
+// rdnValues ... attribute types and values of a single rdn
+func rdnValues(rdn string) (values []*ldap.AttributeTypeAndValue, err error) {
+	parsed, err := ldap.ParseDN(rdn)
+	if err != nil {
+		return
+	}
+	if len(parsed.RDNs) != 1 {
+		err = fmt.Errorf("invalid rdn %s", rdn)
+		return
+	}
+	return parsed.RDNs[0].Attributes, nil
+}
+
+// dnDepth ... number of rdns of dn, parents sort before their children
+func dnDepth(dn string) int {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.Count(dn, ",") + 1
+	}
+	return len(parsed.RDNs)
+}
+
+// removeValue ... values without value, compared case-insensitively
+func removeValue(values []string, value string) (out []string) {
+	for _, v := range values {
+		if !strings.EqualFold(v, value) {
+			out = append(out, v)
+		}
+	}
+	return
+}
+
+// moveEntry ... copy leaf entry dn to its new rdn and superior, then delete it
+func (lc *LDAPClient) moveEntry(dn string, newRDN string, deleteOldRDN bool, newSuperior string) (err error) {
+	oldRDN, parent := splitDN(dn)
+	if newSuperior != "" {
+		parent = newSuperior
+	}
+	newDN := newRDN
+	if parent != "" {
+		newDN += "," + parent
+	}
+	children := ldap.NewSearchRequest(dn, ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"1.1"}, nil)
+	sr, err := lc.search(children)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return
+	}
+	if sr != nil && len(sr.Entries) > 0 {
+		return fmt.Errorf("%s has children, only leaf entries can be renamed", dn)
+	}
+	entry, err := lc.ReadEntry(dn, []string{})
+	if err != nil {
+		return
+	}
+	attrs := entry.Attributes
+	if deleteOldRDN {
+		oldValues, perr := rdnValues(oldRDN)
+		if perr != nil {
+			return perr
+		}
+		for _, v := range oldValues {
+			for name := range attrs {
+				if strings.EqualFold(name, v.Type) {
+					attrs[name] = removeValue(attrs[name], v.Value)
+				}
+			}
+		}
+	}
+	newValues, err := rdnValues(newRDN)
+	if err != nil {
+		return
+	}
+	for _, v := range newValues {
+		name := v.Type
+		for existing := range attrs {
+			if strings.EqualFold(existing, v.Type) {
+				name = existing
+			}
+		}
+		attrs[name] = append(removeValue(attrs[name], v.Value), v.Value)
+	}
+	addrequest := ldap.NewAddRequest(newDN)
+	for _, name := range sortedAttrNames(attrs) {
+		if len(attrs[name]) > 0 {
+			addrequest.Attribute(name, attrs[name])
+		}
+	}
+	if err = lc.Add(addrequest); err != nil {
+		return
+	}
+	return lc.Del(ldap.NewDelRequest(dn, nil))
+}
+
+// ApplyLDIFRecord ... send the add, delete, modify or modrdn of record.
+// Changes of a modify are sent as one request, adds before deletes before replaces
+func (lc *LDAPClient) ApplyLDIFRecord(record LDIFRecord) (err error) {
+	switch record.ChangeType {
+	case ChangeAdd:
+		addrequest := ldap.NewAddRequest(record.DN)
+		for _, name := range sortedAttrNames(record.Attributes) {
+			addrequest.Attribute(name, record.Attributes[name])
+		}
+		return lc.Add(addrequest)
+	case ChangeDelete:
+		return lc.Del(ldap.NewDelRequest(record.DN, nil))
+	case ChangeModify:
+		modify := ldap.NewModifyRequest(record.DN)
+		for _, change := range record.Changes {
+			switch change.Op {
+			case "add":
+				modify.Add(change.Attr, change.Values)
+			case "delete":
+				modify.Delete(change.Attr, change.Values)
+			case "replace":
+				modify.Replace(change.Attr, change.Values)
+			}
+		}
+		return lc.Modify(modify)
+	case ChangeModRDN, ChangeModDN:
+		return lc.ModifyDN(record.DN, record.NewRDN, record.DeleteOldRDN, record.NewSuperior)
+	}
+	return fmt.Errorf("unknown changetype %s", record.ChangeType)
+}
+
+// ExportEntries ... all entries below the bases matching filter, parents first
+func (lc *LDAPClient) ExportEntries(bases []string, filter string) (entries []LdapResult, err error) {
+	for _, base := range bases {
+		data, serr := lc.SearchEntries(filter, []string{}, base)
+		if serr != nil {
+			err = serr
+			return
+		}
+		entries = append(entries, data...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return dnDepth(entries[i].DN) < dnDepth(entries[j].DN)
+	})
+	return
+}
+
+// Export ... write entries below bases as LDIF to path, stdout if empty
+func Export(lc *LDAPClient, bases []string, filter string, path string) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	if len(bases) == 0 {
+		bases = []string{lc.PeopleDn(), lc.GroupDn()}
+	}
+	entries, err := lc.ExportEntries(bases, filter)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, ferr := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if ferr != nil {
+			fmt.Println("ERROR: ", ferr.Error())
+			return ferr
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err = io.WriteString(w, "version: 1\n\n"); err == nil {
+		err = WriteLDIF(w, entries)
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
+
+// ImportLDIF ... apply the records of an LDIF file, stopping at the first
+// failure unless continueOnError. Files with delete or rename records are only
+// applied if confirm agrees to their number
+func ImportLDIF(lc *LDAPClient, path string, continueOnError bool, confirm func(deletes int, renames int) bool) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	defer f.Close()
+	records, err := ReadLDIF(f)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	deletes, renames := 0, 0
+	for _, record := range records {
+		switch record.ChangeType {
+		case ChangeDelete:
+			deletes++
+		case ChangeModRDN, ChangeModDN:
+			renames++
+		}
+	}
+	if deletes+renames > 0 && !confirm(deletes, renames) {
+		fmt.Println("aborted")
+		return errors.New("aborted")
+	}
+
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	applied, failed := 0, 0
+	for _, record := range records {
+		if rerr := lc.ApplyLDIFRecord(record); rerr != nil {
+			failed++
+			fmt.Printf("ERROR:  line %d %s %s: %s\n", record.Line, record.ChangeType, record.DN, rerr.Error())
+			if !continueOnError {
+				break
+			}
+			continue
+		}
+		applied++
+		if !lc.DryRun {
+			fmt.Println(record.ChangeType, record.DN)
+		}
+	}
+	fmt.Printf("%d applied, %d failed, %d skipped\n", applied, failed, len(records)-applied-failed)
+	if failed > 0 {
+		err = errors.New("import failed")
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_splitDN(t *testing.T) {
+	rdn, parent := splitDN(`cn=a\,b,ou=Group,dc=test,dc=com`)
+	if rdn != `cn=a\,b` || parent != "ou=Group,dc=test,dc=com" {
+		t.Fatalf("unexpected split: %s %s", rdn, parent)
+	}
+}
+
+func Test_importLDIFConfirm(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+
+	dir, err := ioutil.TempDir("", "userctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "changes.ldif")
+	content := "dn: uid=carol,ou=People,dc=test,dc=com\n" +
+		"changetype: delete\n" +
+		"\n" +
+		"dn: uid=bob,ou=People,dc=test,dc=com\n" +
+		"changetype: modrdn\n" +
+		"newrdn: uid=robert\n" +
+		"deleteoldrdn: 1\n" +
+		"\n" +
+		"dn: cn=dev,ou=Group,dc=test,dc=com\n" +
+		"changetype: modify\n" +
+		"add: memberUid\n" +
+		"memberUid: carol\n" +
+		"-\n"
+	if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var asked []int
+	captureStdout(t, func() {
+		err = ImportLDIF(lc, path, false, func(deletes int, renames int) bool {
+			asked = []int{deletes, renames}
+			return false
+		})
+	})
+	if err == nil || len(asked) != 2 || asked[0] != 1 || asked[1] != 1 {
+		t.Fatalf("deletes and renames not confirmed: %v %v", asked, err)
+	}
+	if len(s.Writes()) != 0 {
+		t.Fatalf("refused import wrote: %v", s.Writes())
+	}
+
+	captureStdout(t, func() {
+		err = ImportLDIF(lc, path, false, func(int, int) bool { return true })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.entry("uid=robert,ou=People,dc=test,dc=com"); !ok {
+		t.Fatal("confirmed import not applied")
+	}
+}
@@ -0,0 +1,475 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "gopkg.in/asn1-ber.v1"
+	ldap "gopkg.in/ldap.v2"
+)
+
+// fakeServer ... in-memory directory speaking enough LDAP for the client:
+// bind, search with the usual filters, add, modify, delete and the password
+// modify extended operation. Writes are logged in order as "<op> <dn>"
+type fakeServer struct {
+	t  *testing.T
+	l  net.Listener
+	mu sync.Mutex
+	// entries by normalized dn
+	entries map[string]LdapResult
+	writes  []string
+	// unwilling holds writes, as logged, refused with unwillingToPerform
+	unwilling map[string]bool
+}
+
+// newFakeServer ... server holding entries, each parent added before its children
+func newFakeServer(t *testing.T, entries ...LdapResult) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{t: t, l: l, entries: map[string]LdapResult{}}
+	for _, entry := range entries {
+		s.put(entry)
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string { return s.l.Addr().String() }
+
+func (s *fakeServer) Close() { s.l.Close() }
+
+// client ... unconnected client of the server below basedn
+func (s *fakeServer) client(basedn string, profile *Profile) *LDAPClient {
+	return &LDAPClient{Addr: s.Addr(), BaseDn: basedn, Profile: profile}
+}
+
+func normDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	var rdns []string
+	for _, rdn := range parsed.RDNs {
+		var parts []string
+		for _, attr := range rdn.Attributes {
+			parts = append(parts, strings.ToLower(attr.Type)+"="+strings.ToLower(attr.Value))
+		}
+		rdns = append(rdns, strings.Join(parts, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
+
+func parentDN(dn string) string {
+	if i := strings.Index(dn, ","); i >= 0 {
+		return dn[i+1:]
+	}
+	return ""
+}
+
+func (s *fakeServer) put(entry LdapResult) {
+	attrs := map[string][]string{}
+	for k, v := range entry.Attributes {
+		attrs[k] = append([]string{}, v...)
+	}
+	s.entries[normDN(entry.DN)] = LdapResult{DN: entry.DN, Attributes: attrs}
+}
+
+// entry ... copy of the entry at dn
+func (s *fakeServer) entry(dn string) (LdapResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[normDN(dn)]
+	return entry, ok
+}
+
+// Writes ... the writes received so far
+func (s *fakeServer) Writes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.writes...)
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *fakeServer) handle(c net.Conn) {
+	defer c.Close()
+	for {
+		packet, err := ber.ReadPacket(c)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			responses = append(responses, result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, ""))
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationSearchRequest:
+			responses = s.search(op)
+		case ldap.ApplicationAddRequest:
+			code, msg := s.add(op)
+			responses = append(responses, result(ldap.ApplicationAddResponse, code, msg))
+		case ldap.ApplicationModifyRequest:
+			code, msg := s.modify(op)
+			responses = append(responses, result(ldap.ApplicationModifyResponse, code, msg))
+		case ldap.ApplicationDelRequest:
+			code, msg := s.del(op.Data.String())
+			responses = append(responses, result(ldap.ApplicationDelResponse, code, msg))
+		case ldap.ApplicationExtendedRequest:
+			code, msg := s.extended(op)
+			responses = append(responses, result(ldap.ApplicationExtendedResponse, code, msg))
+		default:
+			continue
+		}
+		for _, response := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+			envelope.AppendChild(response)
+			if _, err = c.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(tag ber.Tag, code uint8, msg string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, msg, ""))
+	return op
+}
+
+// attributes ... type and values of a sequence of attribute sequences
+func attributes(packet *ber.Packet) (attrs []ldap.PartialAttribute) {
+	for _, attr := range packet.Children {
+		partial := ldap.PartialAttribute{Type: attr.Children[0].Data.String()}
+		for _, v := range attr.Children[1].Children {
+			partial.Vals = append(partial.Vals, v.Data.String())
+		}
+		attrs = append(attrs, partial)
+	}
+	return
+}
+
+func (s *fakeServer) search(op *ber.Packet) []*ber.Packet {
+	base := normDN(op.Children[0].Data.String())
+	scope := op.Children[1].Value.(int64)
+	sizeLimit := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var wanted []string
+	for _, attr := range op.Children[7].Children {
+		wanted = append(wanted, attr.Data.String())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[base]; !ok && base != "" {
+		return []*ber.Packet{result(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject, "no such object")}
+	}
+	var dns []string
+	for dn := range s.entries {
+		dns = append(dns, dn)
+	}
+	sort.Strings(dns)
+	var responses []*ber.Packet
+	for _, dn := range dns {
+		switch {
+		case scope == ldap.ScopeBaseObject && dn != base:
+			continue
+		case scope == ldap.ScopeSingleLevel && parentDN(dn) != base:
+			continue
+		case scope == ldap.ScopeWholeSubtree && dn != base && !strings.HasSuffix(dn, ","+base) && base != "":
+			continue
+		}
+		entry := s.entries[dn]
+		if !matches(filter, entry) {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(responses)) == sizeLimit {
+			return append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded, ""))
+		}
+		responses = append(responses, searchEntry(entry, wanted))
+	}
+	return append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, ""))
+}
+
+func searchEntry(entry LdapResult, wanted []string) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	all := len(wanted) == 0
+	for _, w := range wanted {
+		all = all || w == "*"
+	}
+	for _, name := range sortedAttrNames(entry.Attributes) {
+		if !all && !containsFold(wanted, name) {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range entry.Attributes[name] {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		attr.AppendChild(values)
+		attrs.AppendChild(attr)
+	}
+	packet.AppendChild(attrs)
+	return packet
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuesFold ... values of attr in entry, dn valued ones normalized
+func valuesFold(entry LdapResult, attr string) (values []string) {
+	for _, v := range entry.Values(attr) {
+		values = append(values, strings.ToLower(v))
+	}
+	return
+}
+
+func matches(filter *ber.Packet, entry LdapResult) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matches(filter.Children[0], entry)
+	case ldap.FilterPresent:
+		return len(entry.Values(filter.Data.String())) > 0
+	case ldap.FilterEqualityMatch, ldap.FilterApproxMatch:
+		attr, want := filter.Children[0].Data.String(), strings.ToLower(filter.Children[1].Data.String())
+		for _, v := range valuesFold(entry, attr) {
+			if v == want || strings.Contains(want, "=") && normDN(v) == normDN(want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual:
+		attr, bound := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+		for _, v := range entry.Values(attr) {
+			var a, b int
+			_, aerr := fmt.Sscan(v, &a)
+			_, berr := fmt.Sscan(bound, &b)
+			if aerr != nil || berr != nil {
+				continue
+			}
+			if filter.Tag == ldap.FilterGreaterOrEqual && a >= b || filter.Tag == ldap.FilterLessOrEqual && a <= b {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		attr := filter.Children[0].Data.String()
+	values:
+		for _, v := range valuesFold(entry, attr) {
+			rest := v
+			for _, part := range filter.Children[1].Children {
+				sub := strings.ToLower(part.Data.String())
+				switch part.Tag {
+				case ldap.FilterSubstringsInitial:
+					if !strings.HasPrefix(rest, sub) {
+						continue values
+					}
+					rest = rest[len(sub):]
+				case ldap.FilterSubstringsAny:
+					i := strings.Index(rest, sub)
+					if i < 0 {
+						continue values
+					}
+					rest = rest[i+len(sub):]
+				case ldap.FilterSubstringsFinal:
+					if !strings.HasSuffix(rest, sub) {
+						continue values
+					}
+				}
+			}
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+func (s *fakeServer) add(op *ber.Packet) (uint8, string) {
+	dn := op.Children[0].Data.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[normDN(dn)]; ok {
+		return ldap.LDAPResultEntryAlreadyExists, "already exists"
+	}
+	if _, ok := s.entries[parentDN(normDN(dn))]; !ok {
+		return ldap.LDAPResultNoSuchObject, "no parent"
+	}
+	entry := LdapResult{DN: dn, Attributes: map[string][]string{}}
+	for _, attr := range attributes(op.Children[1]) {
+		entry.Attributes[attr.Type] = attr.Vals
+	}
+	s.entries[normDN(dn)] = entry
+	s.writes = append(s.writes, "add "+dn)
+	return ldap.LDAPResultSuccess, ""
+}
+
+// attrKey ... key of attr in attrs, attr itself if absent
+func attrKey(attrs map[string][]string, attr string) string {
+	for k := range attrs {
+		if strings.EqualFold(k, attr) {
+			return k
+		}
+	}
+	return attr
+}
+
+func indexFold(values []string, v string) int {
+	for i, value := range values {
+		if strings.EqualFold(value, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *fakeServer) modify(op *ber.Packet) (uint8, string) {
+	dn := op.Children[0].Data.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[normDN(dn)]
+	if !ok {
+		return ldap.LDAPResultNoSuchObject, "no such object"
+	}
+	attrs := map[string][]string{}
+	for k, v := range entry.Attributes {
+		attrs[k] = append([]string{}, v...)
+	}
+	for _, change := range op.Children[1].Children {
+		operation := change.Children[0].Value.(int64)
+		modification := ber.NewSequence("")
+		modification.AppendChild(change.Children[1])
+		attr := attributes(modification)
+		key := attrKey(attrs, attr[0].Type)
+		switch operation {
+		case ldap.AddAttribute:
+			for _, v := range attr[0].Vals {
+				if indexFold(attrs[key], v) >= 0 {
+					return ldap.LDAPResultAttributeOrValueExists, key + ": value exists"
+				}
+				attrs[key] = append(attrs[key], v)
+			}
+		case ldap.DeleteAttribute:
+			if len(attrs[key]) == 0 {
+				return ldap.LDAPResultNoSuchAttribute, key + ": no such attribute"
+			}
+			if len(attr[0].Vals) == 0 {
+				delete(attrs, key)
+			}
+			for _, v := range attr[0].Vals {
+				i := indexFold(attrs[key], v)
+				if i < 0 {
+					return ldap.LDAPResultNoSuchAttribute, key + ": no such value"
+				}
+				attrs[key] = append(attrs[key][:i], attrs[key][i+1:]...)
+			}
+			if len(attrs[key]) == 0 {
+				delete(attrs, key)
+			}
+		case ldap.ReplaceAttribute:
+			delete(attrs, key)
+			if len(attr[0].Vals) > 0 {
+				attrs[attr[0].Type] = attr[0].Vals
+			}
+		}
+	}
+	s.entries[normDN(dn)] = LdapResult{DN: entry.DN, Attributes: attrs}
+	s.writes = append(s.writes, "modify "+dn)
+	return ldap.LDAPResultSuccess, ""
+}
+
+func (s *fakeServer) del(dn string) (uint8, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := normDN(dn)
+	if _, ok := s.entries[key]; !ok {
+		return ldap.LDAPResultNoSuchObject, "no such object"
+	}
+	for other := range s.entries {
+		if parentDN(other) == key {
+			return ldap.LDAPResultNotAllowedOnNonLeaf, "has children"
+		}
+	}
+	delete(s.entries, key)
+	s.writes = append(s.writes, "delete "+dn)
+	return ldap.LDAPResultSuccess, ""
+}
+
+// extended ... password modify, storing the new password as userPassword
+func (s *fakeServer) extended(op *ber.Packet) (uint8, string) {
+	if len(op.Children) < 2 || op.Children[0].Data.String() != "1.3.6.1.4.1.4203.1.11.1" {
+		return ldap.LDAPResultProtocolError, "unsupported extended operation"
+	}
+	value := ber.DecodePacket(op.Children[1].Data.Bytes())
+	var dn, password string
+	for _, child := range value.Children {
+		switch child.Tag {
+		case 0:
+			dn = child.Data.String()
+		case 2:
+			password = child.Data.String()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[normDN(dn)]
+	if !ok {
+		return ldap.LDAPResultNoSuchObject, "no such object"
+	}
+	if s.unwilling["passwd "+dn] {
+		return ldap.LDAPResultUnwillingToPerform, "refused"
+	}
+	entry.Attributes[attrKey(entry.Attributes, "userPassword")] = []string{password}
+	s.writes = append(s.writes, "passwd "+dn)
+	return ldap.LDAPResultSuccess, ""
+}
+
+// fixture ... entry of dn with attrs given as name, value pairs
+func fixture(dn string, pairs ...string) LdapResult {
+	entry := LdapResult{DN: dn, Attributes: map[string][]string{}}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		entry.Attributes[pairs[i]] = append(entry.Attributes[pairs[i]], pairs[i+1])
+	}
+	return entry
+}
@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// sambaGroupType values
+const (
+	GroupTypeDomain    = 2
+	GroupTypeLocal     = 4
+	GroupTypeWellKnown = 5
+)
+
+// groupTypeNames ... names accepted for sambaGroupType
+var groupTypeNames = map[string]int{
+	"domain":     GroupTypeDomain,
+	"local":      GroupTypeLocal,
+	"well-known": GroupTypeWellKnown,
+	"builtin":    GroupTypeWellKnown,
+}
+
+// ParseGroupType ... sambaGroupType from its name or number
+func ParseGroupType(s string) (groupType int, err error) {
+	if t, ok := groupTypeNames[strings.ToLower(s)]; ok {
+		return t, nil
+	}
+	groupType, err = strconv.Atoi(s)
+	if err != nil || (groupType != GroupTypeDomain && groupType != GroupTypeLocal && groupType != GroupTypeWellKnown) {
+		err = fmt.Errorf("unknown group type %s, one of domain, local, well-known", s)
+	}
+	return
+}
+
+// GroupMapping ... samba mapping of a posix group
+type GroupMapping struct {
+	// SID wins over RID; without both the algorithmic sid of the gidNumber is used
+	SID         string
+	RID         int
+	Type        int
+	DisplayName string
+	SIDList     []string
+}
+
+// wellKnownGroup ... domain group with a fixed rid
+type wellKnownGroup struct {
+	Name string
+	Gid  string
+	RID  int
+}
+
+// wellKnownGroups ... groups created by BootstrapGroupMappings, gids as smbldap-populate
+var wellKnownGroups = []wellKnownGroup{
+	{"Domain Admins", "512", 512},
+	{"Domain Users", "513", 513},
+	{"Domain Guests", "514", 514},
+}
+
+// mappingSid ... sambaSID of mapping for a group with gidNumber gidStr
+func (lc *LDAPClient) mappingSid(m GroupMapping, gidStr string) (sid string, err error) {
+	if m.SID != "" {
+		return m.SID, nil
+	}
+	if m.RID > 0 {
+		domain, derr := lc.LookupSambaDomain()
+		if derr != nil {
+			return "", derr
+		}
+		return domain.Sid(m.RID), nil
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return
+	}
+	return lc.GroupSid(gid)
+}
+
+// errSambaDisabled ... group mapping attempted without samba
+var errSambaDisabled = errors.New("group mappings need samba: the profile has no samba attributes, --no-samba is set or the server lacks the samba schema")
+
+// mappingAttributes ... sambaGroupMapping attributes of m
+func (lc *LDAPClient) mappingAttributes(m GroupMapping, gidStr string) (attrs map[string][]string, err error) {
+	sid, err := lc.mappingSid(m, gidStr)
+	if err != nil {
+		return
+	}
+	if m.Type == 0 {
+		m.Type = GroupTypeDomain
+	}
+	attrs = map[string][]string{
+		"sambaSID":       {sid},
+		"sambaGroupType": {strconv.Itoa(m.Type)},
+	}
+	if m.DisplayName != "" {
+		attrs["displayName"] = []string{m.DisplayName}
+	}
+	if len(m.SIDList) > 0 {
+		attrs["sambaSIDList"] = m.SIDList
+	}
+	return
+}
+
+// MapGroup ... add a sambaGroupMapping to an existing group
+func (lc *LDAPClient) MapGroup(groupname string, m GroupMapping) (err error) {
+	if !lc.SambaEnabled() {
+		return errSambaDisabled
+	}
+	gid, err := lc.GroupGidNumber(groupname)
+	if err != nil {
+		return
+	}
+	attrs, err := lc.mappingAttributes(m, gid)
+	if err != nil {
+		return
+	}
+	attrs["objectClass"] = []string{"sambaGroupMapping"}
+	modify := ldap.NewModifyRequest(lc.GroupEntryDn(groupname))
+	for _, name := range sortedAttrNames(attrs) {
+		modify.Add(name, attrs[name])
+	}
+	return lc.Modify(modify)
+}
+
+// ModifyGroupMapping ... replace mapping attributes of group, empty values delete the attribute
+func (lc *LDAPClient) ModifyGroupMapping(groupname string, attrs map[string][]string) (err error) {
+	if !lc.SambaEnabled() {
+		return errSambaDisabled
+	}
+	modify := ldap.NewModifyRequest(lc.GroupEntryDn(groupname))
+	for _, name := range sortedAttrNames(attrs) {
+		modify.Replace(name, attrs[name])
+	}
+	if len(modify.ReplaceAttributes) == 0 {
+		return
+	}
+	return lc.Modify(modify)
+}
+
+// UnmapGroup ... remove the sambaGroupMapping of group, keeping the posix group
+func (lc *LDAPClient) UnmapGroup(groupname string) (err error) {
+	if !lc.SambaEnabled() {
+		return errSambaDisabled
+	}
+	filter := fmt.Sprintf("(&(cn=%s)(objectClass=sambaGroupMapping))", ldap.EscapeFilter(groupname))
+	data, err := lc.Search(filter, []string{"sambaSID", "sambaGroupType", "displayName", "sambaSIDList"}, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	modify := ldap.NewModifyRequest(data[0].DN)
+	modify.Delete("objectClass", []string{"sambaGroupMapping"})
+	for _, name := range sortedAttrNames(data[0].Attributes) {
+		modify.Delete(name, nil)
+	}
+	return lc.Modify(modify)
+}
+
+// BootstrapGroupMappings ... create Domain Admins, Domain Users and Domain Guests
+// with their well-known rids, mapping them if the posix groups exist
+func (lc *LDAPClient) BootstrapGroupMappings() (results []OperationResult, err error) {
+	if !lc.SambaEnabled() {
+		return nil, errSambaDisabled
+	}
+	for _, g := range wellKnownGroups {
+		result := OperationResult{DN: lc.GroupEntryDn(g.Name)}
+		m := GroupMapping{RID: g.RID, Type: GroupTypeDomain, DisplayName: g.Name}
+		filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(g.Name))
+		data, serr := lc.SearchEntries(filter, []string{"objectClass"}, lc.GroupDn())
+		if serr != nil {
+			err = serr
+			return
+		}
+		switch {
+		case len(data) == 0:
+			attrs, aerr := lc.mappingAttributes(m, g.Gid)
+			if aerr != nil {
+				err = aerr
+				return
+			}
+			if err = lc.AddGroupWithAttributes(g.Name, g.Gid, attrs); err != nil {
+				return
+			}
+			result.Status = StatusCreated
+		case hasObjectClass(data[0], "sambaGroupMapping"):
+			result.Status = StatusUnchanged
+		default:
+			if err = lc.MapGroup(g.Name, m); err != nil {
+				return
+			}
+			result.Status = StatusChanged
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+func hasObjectClass(entry LdapResult, class string) bool {
+	for _, v := range entry.Values("objectClass") {
+		if strings.EqualFold(v, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// MapGroup ... add samba mapping to group
+func MapGroup(lc *LDAPClient, groupname string, m GroupMapping) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	err = lc.MapGroup(groupname, m)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
+
+// ModGroupMapping ... change samba mapping of group
+func ModGroupMapping(lc *LDAPClient, groupname string, attrs map[string][]string) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	err = lc.ModifyGroupMapping(groupname, attrs)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
+
+// UnmapGroup ... remove samba mapping from group
+func UnmapGroup(lc *LDAPClient, groupname string) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	err = lc.UnmapGroup(groupname)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
+
+// BootstrapGroupMappings ... create the well-known domain groups
+func BootstrapGroupMappings(lc *LDAPClient) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	results, err := lc.BootstrapGroupMappings()
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	resultsbytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(resultsbytes)
+	return
+}
+
+// DomainSid ... sid of rid in the samba domain
+func DomainSid(lc *LDAPClient, rid int) (sid string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	domain, err := lc.LookupSambaDomain()
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return domain.Sid(rid), nil
+}
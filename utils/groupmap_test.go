@@ -0,0 +1,35 @@
+package utils
+
+import "testing"
+
+func Test_parseGroupType(t *testing.T) {
+	for s, want := range map[string]int{"domain": 2, "Local": 4, "well-known": 5, "5": 5} {
+		got, err := ParseGroupType(s)
+		if err != nil || got != want {
+			t.Fatalf("ParseGroupType(%s) = %d, %v", s, got, err)
+		}
+	}
+	if _, err := ParseGroupType("3"); err == nil {
+		t.Fatal("expected error for unknown type")
+	}
+}
+
+func Test_groupMappingNeedsSamba(t *testing.T) {
+	for _, lc := range []*LDAPClient{
+		{Profile: ProfileOpenLDAPSamba, NoSamba: true},
+		{Profile: ProfileRFC2307},
+	} {
+		if err := lc.MapGroup("dev", GroupMapping{}); err != errSambaDisabled {
+			t.Fatalf("map without samba: %v", err)
+		}
+		if err := lc.ModifyGroupMapping("dev", map[string][]string{"displayName": {"Dev"}}); err != errSambaDisabled {
+			t.Fatalf("modify mapping without samba: %v", err)
+		}
+		if err := lc.UnmapGroup("dev"); err != errSambaDisabled {
+			t.Fatalf("unmap without samba: %v", err)
+		}
+		if _, err := lc.BootstrapGroupMappings(); err != errSambaDisabled {
+			t.Fatalf("bootstrap without samba: %v", err)
+		}
+	}
+}
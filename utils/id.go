@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Identity ... user and group ids of a user, like coreutils id
+type Identity struct {
+	UID       string `json:"uid"`
+	UIDNumber string `json:"uidNumber"`
+	SambaSID  string `json:"sambaSID,omitempty"`
+	// Groups holds the primary group first
+	Groups []UserGroup `json:"groups"`
+}
+
+func idName(number string, name string) string {
+	if name == "" {
+		return number
+	}
+	return fmt.Sprintf("%s(%s)", number, name)
+}
+
+// String ... id formatted as by coreutils id, plus a line of samba sids
+func (id Identity) String() string {
+	groups := []string{}
+	sids := []string{}
+	for _, group := range id.Groups {
+		groups = append(groups, idName(group.GidNumber, group.Name))
+		if group.SambaSID != "" {
+			sids = append(sids, group.SambaSID)
+		}
+	}
+	out := fmt.Sprintf("uid=%s gid=%s groups=%s",
+		idName(id.UIDNumber, id.UID),
+		idName(id.Groups[0].GidNumber, id.Groups[0].Name),
+		strings.Join(groups, ","))
+	if id.SambaSID != "" || len(sids) > 0 {
+		out += fmt.Sprintf("\nsid=%s groupsids=%s", id.SambaSID, strings.Join(sids, ","))
+	}
+	return out
+}
+
+// Identity ... ids of user computed from uidNumber, gidNumber, memberUid and sambaSID
+func (lc *LDAPClient) Identity(username string) (id Identity, err error) {
+	filter := lc.userFilter(username)
+	users, err := lc.SearchEntries(filter, []string{"uidNumber", "sambaSID"}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	if len(users) == 0 {
+		err = fmt.Errorf("user %s has not existed in ldap", username)
+		return
+	}
+	id.UID = username
+	if v := users[0].Attributes["uidNumber"]; len(v) > 0 {
+		id.UIDNumber = v[0]
+	}
+	if v := users[0].Attributes["sambaSID"]; len(v) > 0 {
+		id.SambaSID = v[0]
+	}
+	id.Groups, err = lc.UserGroups(username)
+	return
+}
+
+// GetIdentity ... get ids of user, as text or json
+func GetIdentity(lc *LDAPClient, name string, asJSON bool) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	id, err := lc.Identity(name)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	if !asJSON {
+		data = id.String()
+		return
+	}
+	idbytes, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(idbytes)
+	return
+}
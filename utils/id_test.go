@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_identityString(t *testing.T) {
+	id := Identity{
+		UID:       "alice",
+		UIDNumber: "50000",
+		Groups: []UserGroup{
+			{Name: "users", GidNumber: "100", Primary: true},
+			{Name: "dev", GidNumber: "2001"},
+			{GidNumber: "2002"},
+		},
+	}
+	expected := "uid=50000(alice) gid=100(users) groups=100(users),2001(dev),2002"
+	if id.String() != expected {
+		t.Fatalf("unexpected id: %s", id.String())
+	}
+}
+
+func Test_identity(t *testing.T) {
+	entries := posixFixture()
+	entries[3].Attributes["memberOf"] = []string{"cn=remote,ou=Other,dc=test,dc=com"}
+	s := newFakeServer(t, append(entries,
+		fixture("cn=users,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "cn", "users", "gidNumber", "100"),
+		fixture("cn=ops,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "objectClass", "groupOfNames",
+			"cn", "ops", "gidNumber", "300", "member", "uid=alice,ou=People,dc=test,dc=com"),
+		fixture("ou=Other,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("cn=remote,ou=Other,dc=test,dc=com", "objectClass", "groupOfNames", "cn", "remote",
+			"member", "uid=alice,ou=People,dc=test,dc=com"),
+	)...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	lc.Membership = MembershipBoth
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	// dev lists alice in memberUid, ops by dn and remote only through memberOf
+	id, err := lc.Identity("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "uid=1001(alice) gid=100(users) groups=100(users),200(dev),300(ops),(remote)"
+	if id.String() != expected {
+		t.Fatalf("unexpected id: %s", id.String())
+	}
+	if !id.Groups[0].Primary {
+		t.Fatalf("primary group not first: %+v", id.Groups)
+	}
+
+	// bob is a member of dev by dn only, his primary group has no entry
+	if id, err = lc.Identity("bob"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, group := range id.Groups {
+		names = append(names, group.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"users", "dev"}) {
+		t.Fatalf("unexpected groups of bob: %v", names)
+	}
+
+	if _, err = lc.Identity("zed"); err == nil || err.Error() != "user zed has not existed in ldap" {
+		t.Fatalf("unexpected error for a missing user: %v", err)
+	}
+}
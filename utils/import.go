@@ -0,0 +1,481 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Status of an imported row
+const (
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// Import fields, any other column is written as an ldap attribute
+const (
+	FieldName      = "name"
+	FieldUIDNumber = "uidNumber"
+	FieldGIDNumber = "gidNumber"
+	FieldPassword  = "password"
+	FieldGroups    = "groups"
+)
+
+// Import file formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// passwordChars ... alphabet of generated passwords
+const passwordChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// defaultPasswordLength ... length of generated passwords
+const defaultPasswordLength = 16
+
+// ImportOptions ... how an import file is read and users are created
+type ImportOptions struct {
+	// Format is csv, json or yaml, by default from the file extension
+	Format string
+	// Columns maps file columns to fields or attributes, "-" drops a column
+	Columns map[string]string
+	// UIDStart is the lowest uidNumber allocated to rows without one
+	UIDStart int
+	// GIDNumber is the primary group of rows without one
+	GIDNumber      string
+	PasswordLength int
+}
+
+// ImportRow ... a user read from an import file
+type ImportRow struct {
+	Line       int
+	Spec       UserSpec
+	Groups     []string
+	Generated  bool
+	exists     bool
+	allocateID bool
+}
+
+// ImportResult ... outcome of one row
+type ImportResult struct {
+	Line      int    `json:"line"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	UIDNumber string `json:"uidNumber,omitempty"`
+	// Password is only reported when it was generated
+	Password string   `json:"password,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// importRecord ... values of one row by column
+type importRecord struct {
+	Line   int
+	Fields map[string][]string
+}
+
+// ParseColumnMap ... column mapping from src=dst pairs
+func ParseColumnMap(pairs []string) (columns map[string]string, err error) {
+	columns = map[string]string{}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			err = fmt.Errorf("invalid column mapping %s, want column=field", pair)
+			return
+		}
+		columns[kv[0]] = kv[1]
+	}
+	return
+}
+
+// importFormat ... format of path, from the extension unless given
+func importFormat(path string, format string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	switch format {
+	case FormatCSV, FormatJSON:
+		return format, nil
+	case FormatYAML, "yml":
+		return FormatYAML, nil
+	}
+	return "", fmt.Errorf("unknown import format %q, one of csv, json, yaml", format)
+}
+
+// readImportRecords ... rows of a csv, json or yaml file
+func readImportRecords(path string, format string) (records []importRecord, err error) {
+	format, err = importFormat(path, format)
+	if err != nil {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if format == FormatCSV {
+		return readCSVRecords(f)
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return
+	}
+	var rows []map[string]interface{}
+	if format == FormatJSON {
+		err = json.Unmarshal(content, &rows)
+	} else {
+		var yamlRows []map[string]interface{}
+		err = yaml.Unmarshal(content, &yamlRows)
+		rows = yamlRows
+	}
+	if err != nil {
+		return
+	}
+	for i, row := range rows {
+		record := importRecord{Line: i + 1, Fields: map[string][]string{}}
+		for column, value := range row {
+			record.Fields[column] = importValues(value)
+		}
+		records = append(records, record)
+	}
+	return
+}
+
+// importValues ... values of a json or yaml scalar or list
+func importValues(value interface{}) (values []string) {
+	switch v := value.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, importValues(item)...)
+		}
+	case float64:
+		values = []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	default:
+		values = []string{fmt.Sprint(v)}
+	}
+	return
+}
+
+// readCSVRecords ... rows of a csv file with a header line
+func readCSVRecords(r io.Reader) (records []importRecord, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return
+	}
+	for line := 2; ; line++ {
+		row, rerr := reader.Read()
+		if rerr == io.EOF {
+			return
+		}
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		record := importRecord{Line: line, Fields: map[string][]string{}}
+		for i, column := range header {
+			if i < len(row) && row[i] != "" {
+				record.Fields[column] = []string{row[i]}
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+// splitGroups ... group names of a groups column, separated by comma, semicolon or space
+func splitGroups(values []string) (groups []string) {
+	for _, v := range values {
+		groups = append(groups, strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == ';' || r == ' '
+		})...)
+	}
+	return
+}
+
+// importRows ... rows of records after column mapping, with the errors found in the file itself
+func importRows(records []importRecord, opts ImportOptions) (rows []ImportRow, errs []string) {
+	names := map[string]int{}
+	uids := map[string]int{}
+	for _, record := range records {
+		row := ImportRow{Line: record.Line, Spec: UserSpec{Attributes: map[string][]string{}}}
+		for column, values := range record.Fields {
+			field := column
+			if mapped, ok := opts.Columns[column]; ok {
+				field = mapped
+			}
+			if field == "-" || len(values) == 0 {
+				continue
+			}
+			switch {
+			case strings.EqualFold(field, FieldName):
+				row.Spec.Name = values[0]
+			case strings.EqualFold(field, FieldUIDNumber):
+				row.Spec.UIDNumber = values[0]
+			case strings.EqualFold(field, FieldGIDNumber):
+				row.Spec.GIDNumber = values[0]
+			case strings.EqualFold(field, FieldPassword):
+				row.Spec.Password = values[0]
+			case strings.EqualFold(field, FieldGroups):
+				row.Groups = splitGroups(values)
+			default:
+				row.Spec.Attributes[field] = values
+			}
+		}
+		if row.Spec.Name == "" {
+			errs = append(errs, fmt.Sprintf("line %d: missing %s", row.Line, FieldName))
+		} else if line, ok := names[row.Spec.Name]; ok {
+			errs = append(errs, fmt.Sprintf("line %d: %s already on line %d", row.Line, row.Spec.Name, line))
+		} else {
+			names[row.Spec.Name] = row.Line
+		}
+		if row.Spec.UIDNumber != "" {
+			if _, err := strconv.Atoi(row.Spec.UIDNumber); err != nil {
+				errs = append(errs, fmt.Sprintf("line %d: invalid uidNumber %s", row.Line, row.Spec.UIDNumber))
+			} else if line, ok := uids[row.Spec.UIDNumber]; ok {
+				errs = append(errs, fmt.Sprintf("line %d: uidNumber %s already on line %d", row.Line, row.Spec.UIDNumber, line))
+			} else {
+				uids[row.Spec.UIDNumber] = row.Line
+			}
+		}
+		if row.Spec.GIDNumber == "" {
+			row.Spec.GIDNumber = opts.GIDNumber
+		}
+		if row.Spec.GIDNumber != "" {
+			if _, err := strconv.Atoi(row.Spec.GIDNumber); err != nil {
+				errs = append(errs, fmt.Sprintf("line %d: invalid gidNumber %s", row.Line, row.Spec.GIDNumber))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+// nextFreeID ... lowest id from start not in used, which it is added to
+func nextFreeID(used map[int]bool, start int) int {
+	id := start
+	for used[id] {
+		id++
+	}
+	used[id] = true
+	return id
+}
+
+// GeneratePassword ... random password of length characters
+func GeneratePassword(length int) (password string, err error) {
+	if length <= 0 {
+		length = defaultPasswordLength
+	}
+	max := big.NewInt(int64(len(passwordChars)))
+	b := make([]byte, length)
+	for i := range b {
+		n, rerr := rand.Int(rand.Reader, max)
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		b[i] = passwordChars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// prepareImport ... check rows against the directory, allocate ids and passwords.
+// Nothing is written if any row is invalid
+func (lc *LDAPClient) prepareImport(rows []ImportRow, opts ImportOptions) (errs []string, err error) {
+	// the free uidNumbers are taken from what the primary holds
+	defer lc.readPrimary()()
+	login := lc.profile().LoginAttr
+	people, err := lc.SearchEntries(lc.profile().UserFilter, []string{login, "uidNumber"}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	existing := map[string]string{}
+	for _, entry := range people {
+		uidNumber := ""
+		if v := entry.Values("uidNumber"); len(v) > 0 {
+			uidNumber = v[0]
+		}
+		for _, name := range entry.Values(login) {
+			existing[name] = uidNumber
+		}
+	}
+	// uidNumbers in use anywhere, including trashed users
+	numbered, err := lc.SearchEntries("(uidNumber=*)", []string{"uidNumber"}, lc.BaseDn)
+	if err != nil {
+		return
+	}
+	used := map[int]bool{}
+	owner := map[string]bool{}
+	for _, entry := range numbered {
+		for _, v := range entry.Values("uidNumber") {
+			owner[v] = true
+			if id, aerr := strconv.Atoi(v); aerr == nil {
+				used[id] = true
+			}
+		}
+	}
+	groups, err := lc.SearchEntries(lc.profile().GroupFilter, []string{"cn", "gidNumber"}, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	groupNames := map[string]bool{}
+	groupGids := map[string]bool{}
+	for _, entry := range groups {
+		for _, name := range entry.Values("cn") {
+			groupNames[name] = true
+		}
+		for _, gid := range entry.Values("gidNumber") {
+			groupGids[gid] = true
+		}
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		if uidNumber, ok := existing[row.Spec.Name]; ok {
+			row.exists = true
+			if row.Spec.UIDNumber != "" && row.Spec.UIDNumber != uidNumber {
+				errs = append(errs, fmt.Sprintf("line %d: user %s exists with uidNumber %s", row.Line, row.Spec.Name, uidNumber))
+			}
+			continue
+		}
+		if row.Spec.UIDNumber != "" && owner[row.Spec.UIDNumber] {
+			errs = append(errs, fmt.Sprintf("line %d: uidNumber %s is already used", row.Line, row.Spec.UIDNumber))
+		}
+		// 0, the default of --gid, needs no group and is Domain Users with samba
+		if gid := row.Spec.GIDNumber; gid != "" && gid != "0" && !groupGids[gid] {
+			errs = append(errs, fmt.Sprintf("line %d: no group has gidNumber %s", row.Line, gid))
+		}
+		for _, group := range row.Groups {
+			if !groupNames[group] {
+				errs = append(errs, fmt.Sprintf("line %d: group %s does not exist", row.Line, group))
+			}
+		}
+		if id, aerr := strconv.Atoi(row.Spec.UIDNumber); aerr == nil {
+			used[id] = true
+		}
+		row.allocateID = row.Spec.UIDNumber == ""
+	}
+	if len(errs) > 0 {
+		return
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		if row.exists {
+			continue
+		}
+		if row.allocateID {
+			row.Spec.UIDNumber = strconv.Itoa(nextFreeID(used, opts.UIDStart))
+		}
+		if row.Spec.Password == "" {
+			if row.Spec.Password, err = GeneratePassword(opts.PasswordLength); err != nil {
+				return
+			}
+			row.Generated = true
+		}
+	}
+	return
+}
+
+// ImportUsers ... create users of rows, reporting each row
+func (lc *LDAPClient) ImportUsers(rows []ImportRow) (results []ImportResult) {
+	for _, row := range rows {
+		result := ImportResult{Line: row.Line, Name: row.Spec.Name, UIDNumber: row.Spec.UIDNumber, Groups: row.Groups}
+		if row.exists {
+			result.Status = StatusSkipped
+			result.Error = "user exists"
+			results = append(results, result)
+			continue
+		}
+		// a user whose groups cannot be joined is removed again
+		err := lc.Transaction(func() error {
+			if err := lc.AddUserSpec(row.Spec); err != nil {
+				return err
+			}
+			for _, group := range row.Groups {
+				if _, err := lc.ModifyMembers(group, []string{row.Spec.Name}, nil); err != nil {
+					return fmt.Errorf("adding to group %s failed: %s", group, err.Error())
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			result.Status = StatusFailed
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Status = StatusCreated
+		if row.Generated {
+			result.Password = row.Spec.Password
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// ImportUsers ... create users from a csv, json or yaml file over one connection
+func ImportUsers(lc *LDAPClient, path string, opts ImportOptions) (data string, err error) {
+	records, err := readImportRecords(path, opts.Format)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	rows, errs := importRows(records, opts)
+	if len(errs) > 0 {
+		return "", importError(errs)
+	}
+
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	errs, err = lc.prepareImport(rows, opts)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	if len(errs) > 0 {
+		return "", importError(errs)
+	}
+	results := lc.ImportUsers(rows)
+	resultsbytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(resultsbytes)
+	for _, result := range results {
+		if result.Status == StatusFailed {
+			err = errors.New("some rows failed")
+		}
+	}
+	return
+}
+
+// importError ... print validation errors, nothing has been written
+func importError(errs []string) error {
+	for _, e := range errs {
+		fmt.Println("ERROR: ", e)
+	}
+	err := fmt.Errorf("%d errors, nothing imported", len(errs))
+	fmt.Println("ERROR: ", err.Error())
+	return err
+}
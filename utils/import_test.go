@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_importRows(t *testing.T) {
+	records, err := readCSVRecords(strings.NewReader("login,uidNumber,mail,groups,note\nalice,2001,alice@test.com,dev;ops,x\nbob,,,dev,\nalice,2001,,,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := ImportOptions{Columns: map[string]string{"login": "name", "note": "-"}, GIDNumber: "100"}
+	rows, errs := importRows(records, opts)
+	if len(rows) != 3 || len(errs) != 2 {
+		t.Fatalf("unexpected rows %d and errors %v", len(rows), errs)
+	}
+	alice := rows[0]
+	if alice.Line != 2 || alice.Spec.Name != "alice" || alice.Spec.UIDNumber != "2001" || alice.Spec.GIDNumber != "100" {
+		t.Fatalf("unexpected row: %+v", alice)
+	}
+	if len(alice.Groups) != 2 || alice.Groups[1] != "ops" {
+		t.Fatalf("unexpected groups: %v", alice.Groups)
+	}
+	if len(alice.Spec.Attributes) != 1 || alice.Spec.Attributes["mail"][0] != "alice@test.com" {
+		t.Fatalf("unexpected attributes: %v", alice.Spec.Attributes)
+	}
+}
+
+func Test_nextFreeID(t *testing.T) {
+	used := map[int]bool{1000: true, 1001: true, 1003: true}
+	if id := nextFreeID(used, 1000); id != 1002 {
+		t.Fatalf("unexpected id %d", id)
+	}
+	if id := nextFreeID(used, 1000); id != 1004 {
+		t.Fatalf("unexpected id %d", id)
+	}
+}
+
+func Test_prepareImport(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	rows := []ImportRow{
+		{Line: 2, Spec: UserSpec{Name: "dave", GIDNumber: "200"}, Groups: []string{"dev"}},
+		{Line: 3, Spec: UserSpec{Name: "erin", GIDNumber: "0"}},
+		{Line: 4, Spec: UserSpec{Name: "frank", GIDNumber: "999"}},
+		{Line: 5, Spec: UserSpec{Name: "grace", UIDNumber: "1001", GIDNumber: "200"}, Groups: []string{"ops"}},
+	}
+	errs, err := lc.prepareImport(rows, ImportOptions{UIDStart: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"line 4: no group has gidNumber 999",
+		"line 5: uidNumber 1001 is already used",
+		"line 5: group ops does not exist",
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	rows = rows[:2]
+	if errs, err = lc.prepareImport(rows, ImportOptions{UIDStart: 2000}); err != nil || len(errs) > 0 {
+		t.Fatalf("valid rows refused: %v %v", errs, err)
+	}
+	if rows[0].Spec.UIDNumber != "2000" || rows[1].Spec.UIDNumber != "2001" || len(s.Writes()) > 0 {
+		t.Fatalf("unexpected rows %+v, writes %v", rows, s.Writes())
+	}
+}
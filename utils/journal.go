@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// journalOp ... inverse of one applied change
+type journalOp struct {
+	desc string
+	undo func(conn *ldap.Conn) error
+	// lost names the attributes the undo cannot put back
+	lost []string
+}
+
+// Journal ... inverses of the changes made in a transaction, oldest first.
+//
+// Servers advertising the LDAP transactions extended operation (RFC 5805)
+// could apply the steps atomically, but ldap.v2 can neither send arbitrary
+// extended operations nor attach the transaction control to updates, so every
+// server gets the journal: each change is recorded with its inverse, read
+// from the entry just before it is changed, and a failed transaction undoes
+// the recorded changes newest first. Secrets the server does not return, like
+// unicodePwd or an ACL protected userPassword, cannot be read back, so a
+// deleted user is recreated without them and the rollback error says so.
+type Journal struct {
+	ops []journalOp
+}
+
+func (j *Journal) record(desc string, undo func(conn *ldap.Conn) error, lost ...string) {
+	j.ops = append(j.ops, journalOp{desc: desc, undo: undo, lost: lost})
+}
+
+// rollback ... undo the recorded changes newest first, continuing past
+// failures so as much as possible is undone
+func (j *Journal) rollback(conn *ldap.Conn) (err error) {
+	var failed []string
+	for i := len(j.ops) - 1; i >= 0; i-- {
+		if uerr := j.ops[i].undo(conn); uerr != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", j.ops[i].desc, uerr.Error()))
+		} else if len(j.ops[i].lost) > 0 {
+			failed = append(failed, fmt.Sprintf("%s: recreated without %s, set them again",
+				j.ops[i].desc, strings.Join(j.ops[i].lost, ", ")))
+		}
+	}
+	j.ops = nil
+	if len(failed) > 0 {
+		err = errors.New(strings.Join(failed, "; "))
+	}
+	return
+}
+
+// modifiedAttrs ... names of the attributes a modify request touches
+func modifiedAttrs(modify *ldap.ModifyRequest) (names []string) {
+	seen := map[string]bool{}
+	for _, attrs := range [][]ldap.PartialAttribute{modify.AddAttributes, modify.DeleteAttributes, modify.ReplaceAttributes} {
+		for _, attr := range attrs {
+			if key := strings.ToLower(attr.Type); !seen[key] {
+				seen[key] = true
+				names = append(names, attr.Type)
+			}
+		}
+	}
+	return
+}
+
+// inverseModify ... modify request replacing the attributes names with
+// their values in before, an empty replace deleting those that were added
+func inverseModify(names []string, before LdapResult) *ldap.ModifyRequest {
+	inverse := ldap.NewModifyRequest(before.DN)
+	for _, name := range names {
+		values := before.Values(name)
+		if values == nil {
+			values = []string{}
+		}
+		inverse.Replace(name, values)
+	}
+	return inverse
+}
+
+// inverseAdd ... add request recreating entry
+func inverseAdd(entry LdapResult) *ldap.AddRequest {
+	addrequest := ldap.NewAddRequest(entry.DN)
+	for _, name := range sortedAttrNames(entry.Attributes) {
+		addrequest.Attribute(name, entry.Attributes[name])
+	}
+	return addrequest
+}
+
+// journalAdd ... record the inverse of adding dn
+func (lc *LDAPClient) journalAdd(dn string) {
+	lc.journal.record("undo add of "+dn, func(conn *ldap.Conn) error {
+		return conn.Del(ldap.NewDelRequest(dn, nil))
+	})
+}
+
+// unreadSecrets ... password attributes entry may have had but were not read:
+// unicodePwd of AD users is never returned, userPassword and sambaNTPassword
+// are often hidden by ACLs
+func unreadSecrets(entry LdapResult) (lost []string) {
+	candidates := []struct {
+		attr    string
+		classes []string
+	}{
+		{"userPassword", []string{"person", "posixAccount", "shadowAccount"}},
+		{"sambaNTPassword", []string{"sambaSamAccount"}},
+		{"unicodePwd", []string{"user"}},
+	}
+	for _, c := range candidates {
+		for _, class := range c.classes {
+			if hasObjectClass(entry, class) {
+				if len(entry.Values(c.attr)) == 0 {
+					lost = append(lost, c.attr)
+				}
+				break
+			}
+		}
+	}
+	return
+}
+
+// journalDel ... read dn over conn and prepare recording the inverse of deleting it
+func (lc *LDAPClient) journalDel(conn *ldap.Conn, dn string) (record func(), err error) {
+	entry, err := readEntry(conn, dn, []string{})
+	if err != nil {
+		return
+	}
+	return func() {
+		lc.journal.record("undo delete of "+dn, func(conn *ldap.Conn) error {
+			return conn.Add(inverseAdd(entry))
+		}, unreadSecrets(entry)...)
+	}, nil
+}
+
+// journalModify ... read the attributes names of dn over conn and prepare
+// recording the inverse of modifying them
+func (lc *LDAPClient) journalModify(conn *ldap.Conn, dn string, names []string) (record func(), err error) {
+	before, err := readEntry(conn, dn, names)
+	if err != nil {
+		return
+	}
+	inverse := inverseModify(names, before)
+	return func() {
+		lc.journal.record("undo modify of "+dn, func(conn *ldap.Conn) error {
+			return conn.Modify(inverse)
+		})
+	}, nil
+}
+
+// Transaction ... run fn, undoing the changes it made if it fails. Transactions
+// nest into the outermost one; dry runs change nothing and need no journal.
+func (lc *LDAPClient) Transaction(fn func() error) (err error) {
+	if lc.DryRun || lc.journal != nil {
+		return fn()
+	}
+	journal := &Journal{}
+	lc.journal = journal
+	err = fn()
+	lc.journal = nil
+	if err == nil {
+		return
+	}
+	undo := lc
+	if lc.Context().Err() != nil {
+		// the done context closed the connections, undo over new ones
+		undo = lc.WithContext(context.Background())
+		undo.pooled, undo.Conn, undo.writeConn = false, nil, nil
+		if cerr := undo.Connect(); cerr != nil {
+			return fmt.Errorf("%s; rollback failed, undo by hand: %s", err.Error(), cerr.Error())
+		}
+		defer undo.Close()
+	}
+	conn, cerr := undo.writer()
+	if cerr != nil {
+		return fmt.Errorf("%s; rollback failed, undo by hand: %s", err.Error(), cerr.Error())
+	}
+	if rerr := journal.rollback(conn); rerr != nil {
+		return fmt.Errorf("%s; rollback failed, undo by hand: %s", err.Error(), rerr.Error())
+	}
+	return
+}
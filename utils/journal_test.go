@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+func Test_journalRollback(t *testing.T) {
+	journal := &Journal{}
+	var undone []string
+	for _, dn := range []string{"cn=first", "cn=second", "cn=third"} {
+		dn := dn
+		journal.record("undo add of "+dn, func(conn *ldap.Conn) error {
+			undone = append(undone, dn)
+			if dn == "cn=second" {
+				return errors.New("busy")
+			}
+			return nil
+		})
+	}
+	err := journal.rollback(nil)
+	if strings.Join(undone, " ") != "cn=third cn=second cn=first" {
+		t.Fatalf("unexpected rollback order: %v", undone)
+	}
+	if err == nil || err.Error() != "undo add of cn=second: busy" {
+		t.Fatalf("unexpected rollback error: %v", err)
+	}
+	if len(journal.ops) != 0 {
+		t.Fatal("journal not emptied by rollback")
+	}
+}
+
+func Test_inverseModify(t *testing.T) {
+	modify := ldap.NewModifyRequest("cn=dev,ou=Group,dc=test,dc=com")
+	modify.Add("memberUid", []string{"bob"})
+	modify.Delete("memberUid", []string{"alice"})
+	modify.Replace("description", []string{"developers"})
+	names := modifiedAttrs(modify)
+	if strings.Join(names, " ") != "memberUid description" {
+		t.Fatalf("unexpected attributes: %v", names)
+	}
+	before := LdapResult{DN: modify.DN, Attributes: map[string][]string{"memberUid": {"alice"}}}
+	inverse := inverseModify(names, before)
+	if inverse.DN != modify.DN || len(inverse.ReplaceAttributes) != 2 {
+		t.Fatalf("unexpected inverse: %+v", inverse)
+	}
+	if v := inverse.ReplaceAttributes[0].Vals; len(v) != 1 || v[0] != "alice" {
+		t.Fatalf("unexpected memberUid values: %v", v)
+	}
+	if v := inverse.ReplaceAttributes[1].Vals; len(v) != 0 {
+		t.Fatalf("added description not deleted: %v", v)
+	}
+}
+
+func Test_rollbackReportsUnreadSecrets(t *testing.T) {
+	ad := LdapResult{DN: "cn=Bob Jones,cn=Users,dc=corp,dc=com", Attributes: map[string][]string{
+		"objectClass": {"top", "person", "organizationalPerson", "user"},
+	}}
+	if lost := unreadSecrets(ad); strings.Join(lost, " ") != "userPassword unicodePwd" {
+		t.Fatalf("unexpected lost secrets: %v", lost)
+	}
+	posix := LdapResult{DN: "uid=bob,ou=People,dc=test,dc=com", Attributes: map[string][]string{
+		"objectClass":  {"posixAccount", "sambaSamAccount"},
+		"userPassword": {"{SSHA}x"},
+	}}
+	if lost := unreadSecrets(posix); strings.Join(lost, " ") != "sambaNTPassword" {
+		t.Fatalf("unexpected lost secrets: %v", lost)
+	}
+	if lost := unreadSecrets(LdapResult{Attributes: map[string][]string{"objectClass": {"posixGroup"}}}); len(lost) > 0 {
+		t.Fatalf("group has no secrets: %v", lost)
+	}
+
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+	err := lc.Transaction(func() error {
+		if err := lc.delUser("carol"); err != nil {
+			return err
+		}
+		return errors.New("later step failed")
+	})
+	if _, ok := s.entry("uid=carol,ou=People,dc=test,dc=com"); !ok {
+		t.Fatal("deleted user not recreated")
+	}
+	if err == nil || !strings.Contains(err.Error(), "undo delete of uid=carol,ou=People,dc=test,dc=com: recreated without userPassword") {
+		t.Fatalf("lost password not reported: %v", err)
+	}
+}
@@ -1,14 +1,14 @@
 package utils
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/md4"
@@ -17,6 +17,7 @@ import (
 )
 
 var (
+	// sambadomain ... legacy domain name, preferred if several domains exist
 	sambadomain = "SAMBA"
 )
 
@@ -26,15 +27,69 @@ type LdapResult struct {
 	Attributes map[string][]string `json:"attributes"`
 }
 
+// Values ... values of attribute name, matched case-insensitively
+func (r LdapResult) Values(name string) []string {
+	if v, ok := r.Attributes[name]; ok {
+		return v
+	}
+	for k, v := range r.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
 // LDAPClient ... type
 type LDAPClient struct {
+	// Addr lists the servers tried on connect, comma or space separated
+	// ldap://, ldaps:// and ldapi:// urls or host:port
 	Addr     string
 	BaseDn   string
 	BindDn   string
 	BindPass string
+	// TLS applies to the host:port servers
 	TLS      bool
 	StartTLS bool
-	Conn     *ldap.Conn
+	// WriteAddr lists the primary servers taking writes, Addr if empty
+	WriteAddr string
+	// Balance is BalanceOrder (default) or BalanceRoundRobin
+	Balance string
+	// DryRun prints add, modify and delete requests instead of sending them
+	DryRun bool
+	// Idempotent treats creating what exists and re-adding or removing
+	// members that are already in the wanted state as unchanged
+	Idempotent bool
+	// Membership selects the group member attributes, see MembershipUID
+	Membership string
+	// Profile describes the schema, OpenLDAP with samba if nil
+	Profile *Profile
+	// NoSamba creates pure posix entries even if the profile uses samba
+	NoSamba bool
+	// SambaDomain is the sambaDomainName used for sids
+	SambaDomain string
+	// RidAllocation is RidAlgorithmic (default) or RidNextRid
+	RidAllocation string
+	// SambaTemplates fill the samba profile attributes of new users
+	SambaTemplates SambaTemplates
+	// Timeout bounds dialing and each request, 0 waits forever
+	Timeout time.Duration
+	Conn    *ldap.Conn
+
+	// sambaSchema caches HasSambaSchema
+	sambaSchema *bool
+	// journal records the changes of the running Transaction
+	journal *Journal
+	// writeConn is the connection to WriteAddr
+	writeConn *ldap.Conn
+	// primaryReads sends searches to writeConn while above zero, see readPrimary
+	primaryReads int
+	// pooled clients belong to a Pool, which connects and closes them
+	pooled bool
+	// ctx closes the connection when done, see WithContext
+	ctx context.Context
+	// unwatch stops closing the connection when ctx is done
+	unwatch chan struct{}
 }
 
 func createSambaNtpPwd(password string) (encpwd string, err error) {
@@ -49,42 +104,60 @@ func createSambaNtpPwd(password string) (encpwd string, err error) {
 	return
 }
 
-// Close ... ldap close
+// Close ... ldap close, left to the pool for pooled clients
 func (lc *LDAPClient) Close() {
+	if lc.pooled {
+		return
+	}
+	lc.disconnect()
+}
+
+func (lc *LDAPClient) disconnect() {
+	if lc.unwatch != nil {
+		close(lc.unwatch)
+		lc.unwatch = nil
+	}
 	if lc.Conn != nil {
 		lc.Conn.Close()
 		lc.Conn = nil
 	}
+	if lc.writeConn != nil {
+		lc.writeConn.Close()
+		lc.writeConn = nil
+	}
 }
 
-// Connect ... ldap connect
+// Connect ... ldap connect, pooled clients are already connected
 func (lc *LDAPClient) Connect() (err error) {
-	if lc.TLS {
-		lc.Conn, err = ldap.DialTLS("tcp", lc.Addr, &tls.Config{InsecureSkipVerify: true})
-	} else {
-		lc.Conn, err = ldap.Dial("tcp", lc.Addr)
-	}
-	if err != nil {
-		return err
-	}
-	if !lc.TLS && lc.StartTLS {
-		err = lc.Conn.StartTLS(&tls.Config{InsecureSkipVerify: true})
-		if err != nil {
-			lc.Conn.Close()
-			return err
-		}
-	}
+	return lc.ConnectContext(lc.Context())
+}
 
-	err = lc.Conn.Bind(lc.BindDn, lc.BindPass)
-	if err != nil {
-		lc.Conn.Close()
-		return err
+// ConnectContext ... ldap connect to the first reachable server of Addr,
+// giving up when ctx is done. Dialing and every later request time out after
+// lc.Timeout, and the connection is closed when ctx is done, which fails its
+// outstanding requests
+func (lc *LDAPClient) ConnectContext(ctx context.Context) (err error) {
+	if lc.pooled && lc.Conn != nil {
+		return nil
 	}
+	lc.Conn, err = lc.connectAny(ctx, lc.Addr, lc.Balance)
 	return err
 }
 
 // Search ... get groups or users
 func (lc *LDAPClient) Search(filter string, attr []string, basedn string) (data []LdapResult, err error) {
+	data, err = lc.SearchEntries(filter, attr, basedn)
+	if err != nil {
+		return
+	}
+	if len(data) == 0 {
+		err = errors.New("Cannot find such group")
+	}
+	return
+}
+
+// SearchEntries ... like Search, but an empty result is not an error
+func (lc *LDAPClient) SearchEntries(filter string, attr []string, basedn string) (data []LdapResult, err error) {
 	searchRequest := ldap.NewSearchRequest(
 		basedn,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
@@ -92,12 +165,12 @@ func (lc *LDAPClient) Search(filter string, attr []string, basedn string) (data
 		attr,
 		nil,
 	)
-	sr, err := lc.Conn.Search(searchRequest)
+	sr, err := lc.search(searchRequest)
 	if err != nil {
-		return
-	}
-	if len(sr.Entries) == 0 {
-		err = errors.New("Cannot find such group")
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			err = nil
+			data = []LdapResult{}
+		}
 		return
 	}
 	results := []LdapResult{}
@@ -131,7 +204,7 @@ func (lc *LDAPClient) Mod(basedn string, opt string, attrKey string, attrValue [
 		return
 	}
 
-	err = lc.Conn.Modify(modify)
+	err = lc.Modify(modify)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
@@ -140,14 +213,19 @@ func (lc *LDAPClient) Mod(basedn string, opt string, attrKey string, attrValue [
 
 // Exist ... check user or group exist
 func (lc *LDAPClient) Exist(filter string) bool {
+	return lc.ExistUnder(lc.BaseDn, filter)
+}
+
+// ExistUnder ... check user or group exist below basedn
+func (lc *LDAPClient) ExistUnder(basedn string, filter string) bool {
 	searchRequest := ldap.NewSearchRequest(
-		lc.BaseDn,
+		basedn,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		filter,
 		[]string{},
 		nil,
 	)
-	sr, err := lc.Conn.Search(searchRequest)
+	sr, err := lc.search(searchRequest)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 		return false
@@ -158,73 +236,150 @@ func (lc *LDAPClient) Exist(filter string) bool {
 	return false
 }
 
+// PeopleDn ... dn of the users ou
+func (lc *LDAPClient) PeopleDn() string {
+	return fmt.Sprintf("%s,%s", lc.profile().PeopleRdn, lc.BaseDn)
+}
+
+// GroupDn ... dn of the groups ou
+func (lc *LDAPClient) GroupDn() string {
+	return fmt.Sprintf("%s,%s", lc.profile().GroupRdn, lc.BaseDn)
+}
+
+// UserDn ... dn of user
+func (lc *LDAPClient) UserDn(username string) string {
+	return fmt.Sprintf("%s=%s,%s", lc.profile().UserRdnAttr, username, lc.PeopleDn())
+}
+
+// UserEntryDn ... dn of the existing user, found by its login attribute as
+// the rdn need not be the username (cn is the display name in AD). UserDn
+// is not guessed for a missing user, in AD it may name a group
+func (lc *LDAPClient) UserEntryDn(username string) (dn string, err error) {
+	data, err := lc.SearchEntries(lc.userFilter(username), []string{"1.1"}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	if len(data) == 0 {
+		err = fmt.Errorf("user %s has not existed in ldap", username)
+		return
+	}
+	return data[0].DN, nil
+}
+
+// GroupEntryDn ... dn of group
+func (lc *LDAPClient) GroupEntryDn(groupname string) string {
+	return fmt.Sprintf("cn=%s,%s", groupname, lc.GroupDn())
+}
+
 // SambadomainSid ... get domain sid
 func (lc *LDAPClient) SambadomainSid() (sid string, err error) {
-	filter := fmt.Sprintf("(sambaDomainName=%s)", sambadomain)
-	attrs := []string{"sambaSID"}
-	data, err := lc.Search(filter, attrs, lc.BaseDn)
-	sid = data[0].Attributes["sambaSID"][0]
+	domain, err := lc.LookupSambaDomain()
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
+		return
 	}
-
+	sid = domain.SID
 	return
 }
 
+// UserSpec ... a new user
+type UserSpec struct {
+	Name      string
+	UIDNumber string
+	// GIDNumber is the primary group, "0" if empty
+	GIDNumber string
+	Password  string
+	// PasswordHash is a {SCHEME} userPassword value stored instead of Password,
+	// no samba password can be derived from it
+	PasswordHash string
+	// Attributes are added to or override the profile's attributes
+	Attributes map[string][]string
+}
+
 // AddUser ... add user
 func (lc *LDAPClient) AddUser(username string, uidStr string, passwd string) (err error) {
-	if lc.Exist("(&(uid=" + username + "))") {
+	return lc.AddUserSpec(UserSpec{Name: username, UIDNumber: uidStr, Password: passwd})
+}
+
+// AddUserSpec ... add user described by spec, undoing its steps if one fails
+func (lc *LDAPClient) AddUserSpec(spec UserSpec) (err error) {
+	return lc.Transaction(func() error {
+		return lc.addUserSpec(spec)
+	})
+}
+
+func (lc *LDAPClient) addUserSpec(spec UserSpec) (err error) {
+	p := lc.profile()
+	username := spec.Name
+	passwd := spec.Password
+	if lc.ExistUnder(lc.PeopleDn(), lc.userFilter(username)) {
 		return errors.New("record has existed in ldap")
 	}
 
-	domainID, err := lc.SambadomainSid()
+	uid, err := strconv.Atoi(spec.UIDNumber)
 	if err != nil {
 		return
 	}
-	curtime := fmt.Sprintf("%d", time.Now().Unix())
-	uid, err := strconv.Atoi(uidStr)
-	if err != nil {
-		return
+	userDn := lc.UserDn(username)
+	samba := lc.SambaEnabled()
+	userAttr := p.userAttributes(spec)
+	if !samba {
+		userAttr["objectClass"] = withoutSambaClasses(userAttr["objectClass"])
 	}
-	sambaSid := fmt.Sprintf("%s-%d", domainID, uid*2+1000)
-	userDn := fmt.Sprintf("uid=%s,ou=People,%s", username, lc.BaseDn)
-	userAttr := make(map[string][]string)
-	userAttr["uid"] = []string{username}
-	userAttr["shadowMin"] = []string{"0"}
-	userAttr["homeDirectory"] = []string{"/home/" + username}
-
-	userAttr["objectClass"] = []string{"top", "person", "organizationalPerson",
-		"inetOrgPerson", "sambaSamAccount", "posixAccount", "shadowAccount"}
-	userAttr["cn"] = []string{username}
-	userAttr["givenName"] = []string{username}
-	userAttr["sn"] = []string{username}
-	userAttr["uid"] = []string{username}
-	userAttr["uidNumber"] = []string{uidStr}
-	userAttr["gidNumber"] = []string{"0"}
-	userAttr["displayName"] = []string{username}
-	userAttr["homeDirectory"] = []string{"/home/" + username}
-	userAttr["loginShell"] = []string{"/bin/bash"}
-	userAttr["sambaSID"] = []string{sambaSid}
-	userAttr["sambaAcctFlags"] = []string{"[U ]"}
-	userAttr["userPassword"] = []string{passwd}
-	// gen ntp pwd
-	ntppwd, err := createSambaNtpPwd(passwd)
-	if err != nil {
-		return
+	if spec.PasswordHash != "" {
+		userAttr["userPassword"] = []string{spec.PasswordHash}
+	} else {
+		pwdAttr, perr := lc.passwordAttributes(passwd)
+		if perr != nil {
+			return perr
+		}
+		for k, v := range pwdAttr {
+			userAttr[k] = v
+		}
+	}
+	if samba {
+		sambaSid, serr := lc.UserSid(uid)
+		if serr != nil {
+			return serr
+		}
+		groupSid, gerr := lc.PrimaryGroupSid(userAttr["gidNumber"][0])
+		if gerr != nil {
+			return gerr
+		}
+		curtime := fmt.Sprintf("%d", time.Now().Unix())
+		userAttr["sambaSID"] = []string{sambaSid}
+		userAttr["sambaPrimaryGroupSID"] = []string{groupSid}
+		userAttr["sambaAcctFlags"] = []string{"[U ]"}
+		if spec.PasswordHash == "" {
+			// gen ntp pwd
+			ntppwd, nerr := createSambaNtpPwd(passwd)
+			if nerr != nil {
+				return nerr
+			}
+			userAttr["sambaNTPassword"] = []string{ntppwd}
+			userAttr["sambaPwdLastSet"] = []string{curtime}
+		}
+		for k, v := range lc.SambaTemplates.attributes(username) {
+			userAttr[k] = v
+		}
+	}
+	for k, v := range spec.Attributes {
+		userAttr[k] = v
 	}
-	userAttr["sambaNTPassword"] = []string{ntppwd}
-	userAttr["sambaPwdLastSet"] = []string{curtime}
 
 	addrequest := ldap.NewAddRequest(userDn)
 	for k, v := range userAttr {
 		addrequest.Attribute(k, v)
 	}
-	if err = lc.Conn.Add(addrequest); err != nil {
+	if err = lc.Add(addrequest); err != nil {
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
+	if p.Password != PasswordExop || spec.PasswordHash != "" {
+		return
+	}
 	passwordModifyRequest := ldap.NewPasswordModifyRequest(userDn, "", passwd)
-	_, err = lc.Conn.PasswordModify(passwordModifyRequest)
+	err = lc.PasswordModify(passwordModifyRequest)
 
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
@@ -232,47 +387,137 @@ func (lc *LDAPClient) AddUser(username string, uidStr string, passwd string) (er
 	return
 }
 
-// ModifyPwd ... change pwd of user
-func (lc *LDAPClient) ModifyPwd(username, password string) (err error) {
-	user := fmt.Sprintf("uid=%s,ou=People,%s", username, lc.BaseDn)
-	passwordModifyRequest := ldap.NewPasswordModifyRequest(user, "", password)
-	_, err = lc.Conn.PasswordModify(passwordModifyRequest)
-
+// ModifyUser ... replace attributes of user, empty values delete the attribute.
+// A new gidNumber also moves sambaPrimaryGroupSID to the matching group
+func (lc *LDAPClient) ModifyUser(username string, attrs map[string][]string) (err error) {
+	dn, err := lc.UserEntryDn(username)
 	if err != nil {
-		log.Fatalf("Password could not be changed: %s", err.Error())
 		return
 	}
+	if gid, ok := attrs["gidNumber"]; ok {
+		if len(gid) != 1 {
+			return errors.New("a user needs exactly one gidNumber")
+		}
+		if _, err = lc.GidGroup(gid[0]); err != nil {
+			return
+		}
+	}
+	modify := ldap.NewModifyRequest(dn)
+	for _, k := range sortedAttrNames(attrs) {
+		modify.Replace(k, attrs[k])
+	}
+	if gid := attrs["gidNumber"]; len(gid) > 0 && lc.SambaEnabled() {
+		groupSid, gerr := lc.PrimaryGroupSid(gid[0])
+		if gerr != nil {
+			return gerr
+		}
+		modify.Replace("sambaPrimaryGroupSID", []string{groupSid})
+	}
+	if len(modify.ReplaceAttributes) == 0 {
+		return
+	}
+	return lc.Modify(modify)
+}
 
-	ntppwd, err := createSambaNtpPwd(password)
+// ModifyPwd ... change pwd of user, undoing its steps if one fails
+func (lc *LDAPClient) ModifyPwd(username, password string) (err error) {
+	return lc.Transaction(func() error {
+		return lc.modifyPwd(username, password)
+	})
+}
+
+func (lc *LDAPClient) modifyPwd(username, password string) (err error) {
+	p := lc.profile()
+	user, err := lc.UserEntryDn(username)
 	if err != nil {
 		return
 	}
-
 	modify := ldap.NewModifyRequest(user)
-	modify.Replace("sambaNTPassword", []string{ntppwd})
-	err = lc.Conn.Modify(modify)
+	if p.Password == PasswordExop {
+		passwordModifyRequest := ldap.NewPasswordModifyRequest(user, "", password)
+		err = lc.PasswordModify(passwordModifyRequest)
+
+		if err != nil {
+			return fmt.Errorf("password could not be changed: %s", err.Error())
+		}
+	} else {
+		pwdAttr, perr := lc.passwordAttributes(password)
+		if perr != nil {
+			return perr
+		}
+		for k, v := range pwdAttr {
+			modify.Replace(k, v)
+		}
+	}
+
+	if lc.SambaEnabled() {
+		ntppwd, nerr := createSambaNtpPwd(password)
+		if nerr != nil {
+			return nerr
+		}
+		modify.Replace("sambaNTPassword", []string{ntppwd})
+	}
+	if len(modify.ReplaceAttributes) == 0 {
+		return
+	}
+	err = lc.Modify(modify)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
 	return
 }
 
-// DelUser ... del user
+// DelUser ... del user and remove it from all groups, undoing its steps if one fails
 func (lc *LDAPClient) DelUser(username string) (err error) {
-	userDn := fmt.Sprintf("uid=%s,ou=People,%s", username, lc.BaseDn)
-	delrequest := ldap.NewDelRequest(userDn, nil)
-	err = lc.Conn.Del(delrequest)
+	return lc.Transaction(func() error {
+		return lc.delUser(username)
+	})
+}
+
+func (lc *LDAPClient) delUser(username string) (err error) {
+	groups, err := lc.UserGroupNames(username)
+	if err != nil {
+		return
+	}
+	for _, groupname := range groups {
+		if _, err = lc.ModifyMembers(groupname, nil, []string{username}); err != nil {
+			return
+		}
+	}
+	dn, err := lc.UserEntryDn(username)
+	if err != nil {
+		return
+	}
+	delrequest := ldap.NewDelRequest(dn, nil)
+	err = lc.Del(delrequest)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
 	return
 }
 
+// UserGroupNames ... names of groups listing username as member
+func (lc *LDAPClient) UserGroupNames(username string) (groups []string, err error) {
+	memberFilter, err := lc.memberFilter(username)
+	if err != nil {
+		return
+	}
+	data, err := lc.SearchEntries(memberFilter, []string{"cn"}, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	for _, group := range data {
+		if cn := group.Attributes["cn"]; len(cn) > 0 {
+			groups = append(groups, cn[0])
+		}
+	}
+	return
+}
+
 // DelGroup ... del group
 func (lc *LDAPClient) DelGroup(groupname string) (err error) {
-	userDn := fmt.Sprintf("cn=%s,ou=Group,%s", groupname, lc.BaseDn)
-	delrequest := ldap.NewDelRequest(userDn, nil)
-	err = lc.Conn.Del(delrequest)
+	delrequest := ldap.NewDelRequest(lc.GroupEntryDn(groupname), nil)
+	err = lc.Del(delrequest)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
@@ -281,29 +526,37 @@ func (lc *LDAPClient) DelGroup(groupname string) (err error) {
 
 // AddGroup ... add group
 func (lc *LDAPClient) AddGroup(groupname string, gidStr string) (err error) {
-	domainID, err := lc.SambadomainSid()
-	if err != nil {
-		return
-	}
+	return lc.AddGroupWithAttributes(groupname, gidStr, nil)
+}
+
+// AddGroupWithAttributes ... add group, attrs are added to or override the profile's attributes
+func (lc *LDAPClient) AddGroupWithAttributes(groupname string, gidStr string, attrs map[string][]string) (err error) {
+	p := lc.profile()
 	gid, err := strconv.Atoi(gidStr)
 	if err != nil {
 		return
 	}
-	sambaSid := fmt.Sprintf("%s-%d", domainID, gid*2+1000)
-	groupDn := fmt.Sprintf("cn=%s,ou=Group,%s", groupname, lc.BaseDn)
-	groupAttr := make(map[string][]string)
-
-	groupAttr["objectClass"] = []string{"top", "posixGroup", "sambaGroupMapping"}
-	groupAttr["cn"] = []string{groupname}
-	groupAttr["gidNumber"] = []string{gidStr}
-	groupAttr["sambaSID"] = []string{sambaSid}
-	groupAttr["sambaGroupType"] = []string{"2"}
+	groupAttr := p.groupAttributes(groupname, gidStr)
+	groupAttr["objectClass"] = lc.withMemberClasses(groupAttr["objectClass"])
+	if !lc.SambaEnabled() {
+		groupAttr["objectClass"] = withoutSambaClasses(groupAttr["objectClass"])
+	} else if _, ok := attrs["sambaSID"]; !ok {
+		sambaSid, serr := lc.GroupSid(gid)
+		if serr != nil {
+			return serr
+		}
+		groupAttr["sambaSID"] = []string{sambaSid}
+		groupAttr["sambaGroupType"] = []string{strconv.Itoa(GroupTypeDomain)}
+	}
+	for k, v := range attrs {
+		groupAttr[k] = v
+	}
 
-	addrequest := ldap.NewAddRequest(groupDn)
+	addrequest := ldap.NewAddRequest(lc.GroupEntryDn(groupname))
 	for k, v := range groupAttr {
 		addrequest.Attribute(k, v)
 	}
-	if err = lc.Conn.Add(addrequest); err != nil {
+	if err = lc.Add(addrequest); err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
 	return
@@ -318,11 +571,10 @@ func GetUsers(lc *LDAPClient) (data string, err error) {
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-	filter := "(objectClass=sambaSamAccount)"
-	attrs := []string{"uid", "uidNumber"}
+	filter := lc.profile().UserFilter
+	attrs := []string{lc.profile().LoginAttr, "uidNumber"}
 
-	basedn := fmt.Sprintf("ou=People,%s", lc.BaseDn)
-	users, err := lc.Search(filter, attrs, basedn)
+	users, err := lc.Search(filter, attrs, lc.PeopleDn())
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 		return
@@ -346,9 +598,9 @@ func GetUserByName(lc *LDAPClient, name string) (data string, err error) {
 		return
 	}
 
-	filter := fmt.Sprintf("(&(uid=%s))", name)
+	filter := lc.userFilter(name)
 	attrs := []string{}
-	basedn := fmt.Sprintf("ou=People,%s", lc.BaseDn)
+	basedn := lc.PeopleDn()
 	user, err := lc.Search(filter, attrs, basedn)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
@@ -375,7 +627,7 @@ func GetUserByID(lc *LDAPClient, uidNumber int) (data string, err error) {
 
 	filter := fmt.Sprintf("(&(uidNumber=%d))", uidNumber)
 	attrs := []string{}
-	basedn := fmt.Sprintf("ou=People,%s", lc.BaseDn)
+	basedn := lc.PeopleDn()
 	user, err := lc.Search(filter, attrs, basedn)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
@@ -391,7 +643,7 @@ func GetUserByID(lc *LDAPClient, uidNumber int) (data string, err error) {
 }
 
 // AddUser ... add user
-func AddUser(lc *LDAPClient, username string, uid string, pwd string) (err error) {
+func AddUser(lc *LDAPClient, spec UserSpec) (data string, err error) {
 	err = lc.Connect()
 	defer lc.Close()
 
@@ -399,7 +651,24 @@ func AddUser(lc *LDAPClient, username string, uid string, pwd string) (err error
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-	err = lc.AddUser(username, uid, pwd)
+	result, err := lc.CreateUser(spec)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return result.JSON()
+}
+
+// ModUser ... replace attributes of user
+func ModUser(lc *LDAPClient, username string, attrs map[string][]string) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	err = lc.ModifyUser(username, attrs)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
@@ -422,8 +691,16 @@ func ModUserPwd(lc *LDAPClient, username string, pwd string) (err error) {
 	return
 }
 
+// DelUserOptions ... what to keep of a deleted user
+type DelUserOptions struct {
+	// Archive is a file the entry is appended to as LDIF before deletion
+	Archive string
+	// Trash moves the entry to ou=Deleted instead of deleting it
+	Trash bool
+}
+
 // DelUser ... del user
-func DelUser(lc *LDAPClient, username string) (err error) {
+func DelUser(lc *LDAPClient, username string, opts DelUserOptions) (err error) {
 	err = lc.Connect()
 	defer lc.Close()
 
@@ -431,7 +708,18 @@ func DelUser(lc *LDAPClient, username string) (err error) {
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-	err = lc.DelUser(username)
+	if opts.Archive != "" {
+		err = lc.ArchiveUser(username, opts.Archive)
+		if err != nil {
+			fmt.Println("ERROR: ", err.Error())
+			return
+		}
+	}
+	if opts.Trash {
+		err = lc.TrashUser(username)
+	} else {
+		err = lc.DelUser(username)
+	}
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
@@ -447,9 +735,9 @@ func GetGroups(lc *LDAPClient) (data string, err error) {
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-	filter := "(objectClass=sambaGroupMapping)"
-	attrs := []string{"cn", "gidNumber", "memberUid"}
-	basedn := fmt.Sprintf("ou=Group,%s", lc.BaseDn)
+	filter := lc.profile().GroupFilter
+	attrs := append([]string{"cn", "gidNumber"}, lc.memberAttrs()...)
+	basedn := lc.GroupDn()
 	groups, err := lc.Search(filter, attrs, basedn)
 	if err != nil {
 		return
@@ -473,9 +761,9 @@ func GetGroupByName(lc *LDAPClient, name string) (data string, err error) {
 		return
 	}
 
-	filter := fmt.Sprintf("(&(cn=%s))", name)
+	filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(name))
 	attrs := []string{}
-	basedn := fmt.Sprintf("ou=Group,%s", lc.BaseDn)
+	basedn := lc.GroupDn()
 	group, err := lc.Search(filter, attrs, basedn)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
@@ -491,7 +779,7 @@ func GetGroupByName(lc *LDAPClient, name string) (data string, err error) {
 }
 
 // AddGroup ... add group
-func AddGroup(lc *LDAPClient, groupname string, gid string) (err error) {
+func AddGroup(lc *LDAPClient, groupname string, gid string) (data string, err error) {
 	err = lc.Connect()
 	defer lc.Close()
 
@@ -499,15 +787,140 @@ func AddGroup(lc *LDAPClient, groupname string, gid string) (err error) {
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-	err = lc.AddGroup(groupname, gid)
+	result, err := lc.CreateGroup(groupname, gid)
 	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return result.JSON()
+}
+
+// DelGroupOptions ... how to treat a group that is still in use
+type DelGroupOptions struct {
+	// Force deletes the group even if it still has members or primary users
+	Force bool
+	// ReassignTo moves users having the group as primary group to this group
+	ReassignTo string
+}
+
+// RemoveGroup ... delete group unless it is in use, checked before anything is
+// written. Users having it as primary group are moved to opts.ReassignTo first
+// and moved back if the delete fails
+func (lc *LDAPClient) RemoveGroup(groupname string, opts DelGroupOptions) (err error) {
+	if opts.ReassignTo == groupname {
+		return errors.New("cannot reassign users to the group being deleted")
+	}
+	members, primaryUsers, err := lc.GroupUsage(groupname)
+	if err != nil {
+		return
+	}
+	if len(members) > 0 {
+		fmt.Printf("group %s still has members: %s\n", groupname, strings.Join(members, ", "))
+	}
+	if len(primaryUsers) > 0 && opts.ReassignTo == "" {
+		fmt.Printf("group %s is the primary group of: %s\n", groupname, strings.Join(primaryUsers, ", "))
+	}
+	if (len(members) > 0 || len(primaryUsers) > 0 && opts.ReassignTo == "") && !opts.Force {
+		return errors.New("group is in use, use --force to delete it anyway")
+	}
+	return lc.Transaction(func() error {
+		if len(primaryUsers) > 0 && opts.ReassignTo != "" {
+			if err := lc.ReassignPrimaryGroup(primaryUsers, opts.ReassignTo); err != nil {
+				return err
+			}
+			fmt.Printf("primary group of %s reassigned to %s\n", strings.Join(primaryUsers, ", "), opts.ReassignTo)
+		}
+		return lc.DelGroup(groupname)
+	})
+}
+
+// GroupGidNumber ... gidNumber of group
+func (lc *LDAPClient) GroupGidNumber(groupname string) (gid string, err error) {
+	filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(groupname))
+	data, err := lc.Search(filter, []string{"gidNumber"}, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	if v := data[0].Attributes["gidNumber"]; len(v) > 0 {
+		gid = v[0]
+		return
 	}
+	err = errors.New("group has no gidNumber")
 	return
 }
 
-// DelGroup ... del user
-func DelGroup(lc *LDAPClient, groupname string) (err error) {
+// GidGroup ... name of the group with gidNumber, erroring if gidNumber is not
+// a number or no group has it
+func (lc *LDAPClient) GidGroup(gidNumber string) (groupname string, err error) {
+	if gid, cerr := strconv.Atoi(gidNumber); cerr != nil || gid < 0 {
+		err = fmt.Errorf("invalid gidNumber %q", gidNumber)
+		return
+	}
+	filter := fmt.Sprintf("(&%s(gidNumber=%s))", lc.profile().GroupFilter, ldap.EscapeFilter(gidNumber))
+	data, err := lc.SearchEntries(filter, []string{"cn"}, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	for _, entry := range data {
+		if cn := entry.Values("cn"); len(cn) > 0 {
+			return cn[0], nil
+		}
+	}
+	err = fmt.Errorf("no group has gidNumber %s", gidNumber)
+	return
+}
+
+// GroupUsage ... members of group and users having it as primary group
+func (lc *LDAPClient) GroupUsage(groupname string) (members []string, primaryUsers []string, err error) {
+	filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(groupname))
+	data, err := lc.Search(filter, append([]string{"gidNumber"}, lc.memberAttrs()...), lc.GroupDn())
+	if err != nil {
+		return
+	}
+	if members, err = lc.memberNames(data[0]); err != nil {
+		return
+	}
+	gid := data[0].Attributes["gidNumber"]
+	if len(gid) == 0 {
+		return
+	}
+	login := lc.profile().LoginAttr
+	filter = fmt.Sprintf("(&(%s=*)(gidNumber=%s))", login, ldap.EscapeFilter(gid[0]))
+	users, err := lc.SearchEntries(filter, []string{login}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	for _, user := range users {
+		if uid := user.Values(login); len(uid) > 0 {
+			primaryUsers = append(primaryUsers, uid[0])
+		}
+	}
+	return
+}
+
+// ReassignPrimaryGroup ... set gidNumber of users to the gidNumber of groupname, undoing its steps if one fails
+func (lc *LDAPClient) ReassignPrimaryGroup(usernames []string, groupname string) (err error) {
+	return lc.Transaction(func() error {
+		return lc.reassignPrimaryGroup(usernames, groupname)
+	})
+}
+
+func (lc *LDAPClient) reassignPrimaryGroup(usernames []string, groupname string) (err error) {
+	gid, err := lc.GroupGidNumber(groupname)
+	if err != nil {
+		return
+	}
+	for _, username := range usernames {
+		// as user mod --gid, so sambaPrimaryGroupSID follows gidNumber
+		if err = lc.ModifyUser(username, map[string][]string{"gidNumber": {gid}}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// DelGroup ... del group unless it is still in use
+func DelGroup(lc *LDAPClient, groupname string, opts DelGroupOptions) (err error) {
 	err = lc.Connect()
 	defer lc.Close()
 
@@ -515,15 +928,14 @@ func DelGroup(lc *LDAPClient, groupname string) (err error) {
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-	err = lc.DelGroup(groupname)
-	if err != nil {
+	if err = lc.RemoveGroup(groupname, opts); err != nil {
 		fmt.Println("ERROR: ", err.Error())
 	}
 	return
 }
 
-// GroupAddMember ... add user to group
-func GroupAddMember(lc *LDAPClient, groupname string, username string) (err error) {
+// GroupAddMembers ... add users to group
+func GroupAddMembers(lc *LDAPClient, groupname string, usernames []string) (data string, err error) {
 	err = lc.Connect()
 	defer lc.Close()
 
@@ -531,21 +943,34 @@ func GroupAddMember(lc *LDAPClient, groupname string, username string) (err erro
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-
-	// check if username existed
-	if !lc.Exist("(&(uid=" + username + "))") {
-		err = errors.New("username has not existed in ldap")
+	change, err := lc.ModifyMembers(groupname, usernames, nil)
+	if err != nil {
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
+	return change.JSON()
+}
 
-	basedn := fmt.Sprintf("cn=%s,ou=Group,%s", groupname, lc.BaseDn)
-	err = lc.Mod(basedn, "add", "memberUid", []string{username})
-	return
+// GroupDelMembers ... del users from group
+func GroupDelMembers(lc *LDAPClient, groupname string, usernames []string) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	change, err := lc.ModifyMembers(groupname, nil, usernames)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return change.JSON()
 }
 
-// GroupAddMember ... del user from group
-func GroupDelMember(lc *LDAPClient, groupname string, username string) (err error) {
+// GroupSetMembers ... make usernames the exact members of group if confirm
+// agrees to the members it removes
+func GroupSetMembers(lc *LDAPClient, groupname string, usernames []string, confirm func(del []string) bool) (data string, err error) {
 	err = lc.Connect()
 	defer lc.Close()
 
@@ -553,7 +978,10 @@ func GroupDelMember(lc *LDAPClient, groupname string, username string) (err erro
 		fmt.Println("ERROR: ", err.Error())
 		return
 	}
-	basedn := fmt.Sprintf("cn=%s,ou=Group,%s", groupname, lc.BaseDn)
-	err = lc.Mod(basedn, "del", "memberUid", []string{username})
-	return
+	change, err := lc.SetMembers(groupname, usernames, confirm)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return change.JSON()
 }
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -39,3 +41,157 @@ func Test_addUser(t *testing.T) {
 		t.Fatalf("error sending message: %v", err)
 	}
 }
+
+func Test_removeGroup(t *testing.T) {
+	s := newFakeServer(t, append(posixFixture(),
+		fixture("cn=staff,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "cn", "staff", "gidNumber", "100"),
+		fixture("cn=old,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "cn", "old", "gidNumber", "300"),
+		fixture("uid=dave,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "dave", "uidNumber", "1004", "gidNumber", "300"),
+	)...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	lc.Membership = MembershipBoth
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	members, primaryUsers, err := lc.GroupUsage("dev")
+	if err != nil || !reflect.DeepEqual(members, []string{"alice", "bob"}) || len(primaryUsers) != 0 {
+		t.Fatalf("unexpected usage of dev: %v %v %v", members, primaryUsers, err)
+	}
+	if _, primaryUsers, _ = lc.GroupUsage("old"); !reflect.DeepEqual(primaryUsers, []string{"dave"}) {
+		t.Fatalf("unexpected primary users of old: %v", primaryUsers)
+	}
+
+	if err = lc.RemoveGroup("dev", DelGroupOptions{ReassignTo: "staff"}); err == nil {
+		t.Fatal("group with members deleted without --force")
+	}
+	if err = lc.RemoveGroup("old", DelGroupOptions{}); err == nil {
+		t.Fatal("primary group deleted without --force")
+	}
+	if writes := s.Writes(); len(writes) > 0 {
+		t.Fatalf("refused delete wrote: %v", writes)
+	}
+
+	if err = lc.RemoveGroup("old", DelGroupOptions{ReassignTo: "staff"}); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := s.entry("uid=dave,ou=People,dc=test,dc=com"); !reflect.DeepEqual(user.Values("gidNumber"), []string{"100"}) {
+		t.Fatalf("primary group not reassigned: %v", user.Values("gidNumber"))
+	}
+	if _, ok := s.entry("cn=old,ou=Group,dc=test,dc=com"); ok {
+		t.Fatal("group not deleted")
+	}
+	if err = lc.RemoveGroup("dev", DelGroupOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_removeGroupSamba(t *testing.T) {
+	s := newFakeServer(t, append(sambaFixture(),
+		fixture("cn=staff,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "objectClass", "sambaGroupMapping",
+			"cn", "staff", "gidNumber", "500", "sambaSID", "S-1-5-21-1-2-3-2001"),
+		fixture("cn=old,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "objectClass", "sambaGroupMapping",
+			"cn", "old", "gidNumber", "300", "sambaSID", "S-1-5-21-1-2-3-1601"),
+		fixture("uid=dave,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "objectClass", "sambaSamAccount",
+			"uid", "dave", "uidNumber", "1004", "gidNumber", "300", "sambaPrimaryGroupSID", "S-1-5-21-1-2-3-1601"),
+	)...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileOpenLDAPSamba)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	if err := lc.RemoveGroup("old", DelGroupOptions{Force: true, ReassignTo: "staff"}); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := s.entry("uid=dave,ou=People,dc=test,dc=com")
+	if !reflect.DeepEqual(user.Values("gidNumber"), []string{"500"}) ||
+		!reflect.DeepEqual(user.Values("sambaPrimaryGroupSID"), []string{"S-1-5-21-1-2-3-2001"}) {
+		t.Fatalf("primary group not reassigned with its sid: %v", user.Attributes)
+	}
+	if _, ok := s.entry("cn=old,ou=Group,dc=test,dc=com"); ok {
+		t.Fatal("group not deleted")
+	}
+}
+
+func Test_modifyUserGid(t *testing.T) {
+	entries := append(sambaFixture(),
+		fixture("cn=admins,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "objectClass", "sambaGroupMapping",
+			"cn", "admins", "gidNumber", "300", "sambaSID", "S-1-5-21-1-2-3-512"))
+	s := newFakeServer(t, entries...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	for gid, want := range map[string]string{
+		"":    `invalid gidNumber ""`,
+		"dev": `invalid gidNumber "dev"`,
+		"-1":  `invalid gidNumber "-1"`,
+		"999": "no group has gidNumber 999",
+	} {
+		if err := lc.ModifyUser("alice", map[string][]string{"gidNumber": {gid}}); err == nil || err.Error() != want {
+			t.Fatalf("gidNumber %q: expected %q, got %v", gid, want, err)
+		}
+	}
+	if err := lc.ModifyUser("alice", map[string][]string{"gidNumber": {}}); err == nil {
+		t.Fatal("gidNumber removed")
+	}
+	if len(s.Writes()) != 0 {
+		t.Fatalf("invalid gidNumber written: %v", s.Writes())
+	}
+	if err := lc.ModifyUser("alice", map[string][]string{"gidNumber": {"200"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sid, err := lc.PrimaryGroupSid("300"); err != nil || sid != "S-1-5-21-1-2-3-512" {
+		t.Fatalf("unexpected sid of mapped group: %s %v", sid, err)
+	}
+	if sid, err := lc.PrimaryGroupSid("200"); err != nil || sid != "S-1-5-21-1-2-3-513" {
+		t.Fatalf("unexpected sid of unmapped group: %s %v", sid, err)
+	}
+	if sid, err := lc.PrimaryGroupSid("999"); err != nil || sid != "S-1-5-21-1-2-3-513" {
+		t.Fatalf("unexpected sid of unknown gidNumber: %s %v", sid, err)
+	}
+}
+
+func Test_addUserDefaultGid(t *testing.T) {
+	s := newFakeServer(t, sambaFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileOpenLDAPSamba)
+
+	// the default of user add --gid, which no group has
+	spec := UserSpec{Name: "dave", UIDNumber: "1004", GIDNumber: "0", Password: "secret"}
+	captureStdout(t, func() {
+		if _, err := AddUser(lc, spec); err != nil {
+			t.Fatal(err)
+		}
+	})
+	user, ok := s.entry("uid=dave,ou=People,dc=test,dc=com")
+	if !ok || !reflect.DeepEqual(user.Values("sambaPrimaryGroupSID"), []string{"S-1-5-21-1-2-3-513"}) {
+		t.Fatalf("user not added with Domain Users: %v", user.Attributes)
+	}
+}
+
+func Test_modifyPwdExopError(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	s.unwilling = map[string]bool{"passwd uid=alice,ou=People,dc=test,dc=com": true}
+	if err := lc.ModifyPwd("alice", "secret"); err == nil || !strings.HasPrefix(err.Error(), "password could not be changed: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lc.ModifyPwd("bob", "secret"); err != nil {
+		t.Fatal(err)
+	}
+}
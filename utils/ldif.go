@@ -0,0 +1,308 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const ldifLineWidth = 76
+
+// WriteLDIF ... write entries as RFC 2849 LDIF content records
+func WriteLDIF(w io.Writer, entries []LdapResult) (err error) {
+	bw := bufio.NewWriter(w)
+	for i, entry := range entries {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		writeLDIFLine(bw, "dn", entry.DN)
+		for _, name := range sortedAttrNames(entry.Attributes) {
+			for _, value := range entry.Attributes[name] {
+				writeLDIFLine(bw, name, value)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// sortedAttrNames ... objectClass first, then the rest alphabetically
+func sortedAttrNames(attrs map[string][]string) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi := strings.EqualFold(names[i], "objectClass")
+		oj := strings.EqualFold(names[j], "objectClass")
+		if oi != oj {
+			return oi
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func writeLDIFLine(w *bufio.Writer, name string, value string) {
+	var line string
+	if ldifSafeString(value) {
+		line = fmt.Sprintf("%s: %s", name, value)
+	} else {
+		line = fmt.Sprintf("%s:: %s", name, base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	// fold long lines, continuation lines start with a single space
+	width := ldifLineWidth
+	for len(line) > width {
+		w.WriteString(line[:width])
+		w.WriteString("\n ")
+		line = line[width:]
+		width = ldifLineWidth - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\n")
+}
+
+// ldifSafeString ... value can be written without base64 (RFC 2849 SAFE-STRING)
+func ldifSafeString(value string) bool {
+	if value == "" {
+		return true
+	}
+	switch value[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	if value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == 0 || c == '\n' || c == '\r' || c > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// Change types of LDIF change records
+const (
+	ChangeAdd    = "add"
+	ChangeDelete = "delete"
+	ChangeModify = "modify"
+	ChangeModRDN = "modrdn"
+	ChangeModDN  = "moddn"
+)
+
+// LDIFChange ... one add, delete or replace of a modify record
+type LDIFChange struct {
+	Op     string
+	Attr   string
+	Values []string
+}
+
+// LDIFRecord ... a content or change record, content records are adds
+type LDIFRecord struct {
+	// Line is where the record starts, for error messages
+	Line       int
+	DN         string
+	ChangeType string
+	// Attributes of an add
+	Attributes map[string][]string
+	// Changes of a modify
+	Changes []LDIFChange
+	// NewRDN, DeleteOldRDN and NewSuperior of a modrdn
+	NewRDN       string
+	DeleteOldRDN bool
+	NewSuperior  string
+}
+
+// ldifLine ... an unfolded line and the line number it starts on
+type ldifLine struct {
+	no   int
+	text string
+}
+
+// ReadLDIF ... parse RFC 2849 content and change records
+func ReadLDIF(r io.Reader) (records []LDIFRecord, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var block []ldifLine
+	first := true
+	flush := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		if first && strings.HasPrefix(block[0].text, "version:") {
+			block = block[1:]
+		}
+		first = false
+		if len(block) == 0 {
+			return nil
+		}
+		record, perr := parseLDIFRecord(block)
+		if perr != nil {
+			return perr
+		}
+		records = append(records, record)
+		block = nil
+		return nil
+	}
+	no := 0
+	comment := false
+	for scanner.Scan() {
+		no++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(text, " "):
+			// continuation of the previous line
+			if !comment && len(block) > 0 {
+				block[len(block)-1].text += text[1:]
+			}
+		case strings.HasPrefix(text, "#"):
+			comment = true
+		case text == "":
+			comment = false
+			if err = flush(); err != nil {
+				return
+			}
+		default:
+			comment = false
+			block = append(block, ldifLine{no: no, text: text})
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	err = flush()
+	return
+}
+
+// parseLDIFValue ... attribute name and value of a line, decoding base64 and file urls
+func parseLDIFValue(line ldifLine) (name string, value string, err error) {
+	i := strings.Index(line.text, ":")
+	if i <= 0 {
+		err = fmt.Errorf("line %d: missing attribute name", line.no)
+		return
+	}
+	name = line.text[:i]
+	rest := line.text[i+1:]
+	switch {
+	case strings.HasPrefix(rest, ":"):
+		decoded, derr := base64.StdEncoding.DecodeString(strings.TrimSpace(rest[1:]))
+		if derr != nil {
+			err = fmt.Errorf("line %d: %s", line.no, derr.Error())
+			return
+		}
+		value = string(decoded)
+	case strings.HasPrefix(rest, "<"):
+		url := strings.TrimSpace(rest[1:])
+		if !strings.HasPrefix(url, "file://") {
+			err = fmt.Errorf("line %d: only file:// urls are supported", line.no)
+			return
+		}
+		content, rerr := ioutil.ReadFile(strings.TrimPrefix(url, "file://"))
+		if rerr != nil {
+			err = fmt.Errorf("line %d: %s", line.no, rerr.Error())
+			return
+		}
+		value = string(content)
+	default:
+		value = strings.TrimLeft(rest, " ")
+	}
+	return
+}
+
+// parseLDIFRecord ... record of the lines between two blank lines
+func parseLDIFRecord(lines []ldifLine) (record LDIFRecord, err error) {
+	record.Line = lines[0].no
+	name, value, err := parseLDIFValue(lines[0])
+	if err != nil {
+		return
+	}
+	if !strings.EqualFold(name, "dn") {
+		err = fmt.Errorf("line %d: record does not start with dn", lines[0].no)
+		return
+	}
+	record.DN = value
+	lines = lines[1:]
+	record.ChangeType = ChangeAdd
+	if len(lines) > 0 {
+		if name, value, err = parseLDIFValue(lines[0]); err != nil {
+			return
+		}
+		if strings.EqualFold(name, "control") {
+			err = fmt.Errorf("line %d: controls are not supported", lines[0].no)
+			return
+		}
+		if strings.EqualFold(name, "changetype") {
+			record.ChangeType = strings.ToLower(value)
+			lines = lines[1:]
+		}
+	}
+
+	switch record.ChangeType {
+	case ChangeAdd:
+		record.Attributes = map[string][]string{}
+		for _, line := range lines {
+			if name, value, err = parseLDIFValue(line); err != nil {
+				return
+			}
+			record.Attributes[name] = append(record.Attributes[name], value)
+		}
+	case ChangeDelete:
+		if len(lines) > 0 {
+			err = fmt.Errorf("line %d: unexpected content in delete record", lines[0].no)
+		}
+	case ChangeModify:
+		var change *LDIFChange
+		for _, line := range lines {
+			if line.text == "-" {
+				change = nil
+				continue
+			}
+			if name, value, err = parseLDIFValue(line); err != nil {
+				return
+			}
+			if change == nil {
+				op := strings.ToLower(name)
+				if op != "add" && op != "delete" && op != "replace" {
+					err = fmt.Errorf("line %d: unknown modify operation %s", line.no, name)
+					return
+				}
+				record.Changes = append(record.Changes, LDIFChange{Op: op, Attr: value})
+				change = &record.Changes[len(record.Changes)-1]
+				continue
+			}
+			if !strings.EqualFold(name, change.Attr) {
+				err = fmt.Errorf("line %d: %s in a change of %s, missing -", line.no, name, change.Attr)
+				return
+			}
+			change.Values = append(change.Values, value)
+		}
+	case ChangeModRDN, ChangeModDN:
+		for _, line := range lines {
+			if name, value, err = parseLDIFValue(line); err != nil {
+				return
+			}
+			switch strings.ToLower(name) {
+			case "newrdn":
+				record.NewRDN = value
+			case "deleteoldrdn":
+				record.DeleteOldRDN = value == "1"
+			case "newsuperior":
+				record.NewSuperior = value
+			default:
+				err = fmt.Errorf("line %d: unexpected %s in modrdn record", line.no, name)
+				return
+			}
+		}
+		if record.NewRDN == "" {
+			err = fmt.Errorf("line %d: modrdn record without newrdn", record.Line)
+		}
+	default:
+		err = fmt.Errorf("line %d: unknown changetype %s", record.Line, record.ChangeType)
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_writeLDIF(t *testing.T) {
+	entries := []LdapResult{{
+		DN: "uid=test1,ou=People,dc=test,dc=com",
+		Attributes: map[string][]string{
+			"uid":         {"test1"},
+			"objectClass": {"top", "posixAccount"},
+			"cn":          {"Jürgen"},
+			"description": {" leading space"},
+		},
+	}}
+	var buf bytes.Buffer
+	if err := WriteLDIF(&buf, entries); err != nil {
+		t.Fatalf("error writing ldif: %v", err)
+	}
+	expected := "dn: uid=test1,ou=People,dc=test,dc=com\n" +
+		"objectClass: top\n" +
+		"objectClass: posixAccount\n" +
+		"cn:: SsO8cmdlbg==\n" +
+		"description:: IGxlYWRpbmcgc3BhY2U=\n" +
+		"uid: test1\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected ldif:\n%s", buf.String())
+	}
+}
+
+func Test_readLDIF(t *testing.T) {
+	input := "version: 1\n" +
+		"# comment\n" +
+		"dn: uid=test1,ou=People,dc=test,dc=com\n" +
+		"objectClass: top\n" +
+		"cn:: SsO8cmdl\n" +
+		" bg==\n" +
+		"\n" +
+		"dn: cn=dev,ou=Group,dc=test,dc=com\n" +
+		"changetype: modify\n" +
+		"add: memberUid\n" +
+		"memberUid: test1\n" +
+		"-\n" +
+		"delete: description\n" +
+		"-\n" +
+		"\n" +
+		"dn: uid=test2,ou=People,dc=test,dc=com\n" +
+		"changetype: modrdn\n" +
+		"newrdn: uid=test3\n" +
+		"deleteoldrdn: 1\n"
+	records, err := ReadLDIF(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("error reading ldif: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if records[0].ChangeType != ChangeAdd || records[0].Attributes["cn"][0] != "Jürgen" || records[0].Line != 3 {
+		t.Fatalf("unexpected add record: %+v", records[0])
+	}
+	changes := records[1].Changes
+	if len(changes) != 2 || changes[0].Op != "add" || changes[0].Values[0] != "test1" || changes[1].Attr != "description" {
+		t.Fatalf("unexpected modify record: %+v", records[1])
+	}
+	if records[2].NewRDN != "uid=test3" || !records[2].DeleteOldRDN {
+		t.Fatalf("unexpected modrdn record: %+v", records[2])
+	}
+	if _, err := ReadLDIF(strings.NewReader("cn: x\n")); err == nil {
+		t.Fatal("expected error for record without dn")
+	}
+}
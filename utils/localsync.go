@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LocalSyncOptions ... local account files and the id ranges to sync
+type LocalSyncOptions struct {
+	Passwd string
+	Group  string
+	// Shadow is optional, hashes then come from the passwd file
+	Shadow string
+	// UIDMin to UIDMax and GIDMin to GIDMax skip system accounts
+	UIDMin int
+	UIDMax int
+	GIDMin int
+	GIDMax int
+}
+
+// passwdEntry ... a line of /etc/passwd
+type passwdEntry struct {
+	Name  string
+	Hash  string
+	UID   int
+	GID   string
+	Gecos string
+	Home  string
+	Shell string
+}
+
+// groupEntry ... a line of /etc/group
+type groupEntry struct {
+	Name    string
+	GID     int
+	Members []string
+}
+
+// shadowEntry ... a line of /etc/shadow, fields after the hash in order
+// lastchg, min, max, warn, inactive, expire
+type shadowEntry struct {
+	Hash   string
+	Fields []string
+}
+
+// shadowAttrs ... attributes of the shadow fields after the hash
+var shadowAttrs = []string{"shadowLastChange", "shadowMin", "shadowMax", "shadowWarning", "shadowInactive", "shadowExpire"}
+
+// readColonFile ... fields of the non-empty, non-comment lines of a colon separated file
+func readColonFile(path string, fields int) (lines [][]string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for no := 1; scanner.Scan(); no++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) < fields {
+			err = fmt.Errorf("%s:%d: expected %d fields", path, no, fields)
+			return
+		}
+		lines = append(lines, parts)
+	}
+	err = scanner.Err()
+	return
+}
+
+func readPasswd(path string) (entries []passwdEntry, err error) {
+	lines, err := readColonFile(path, 7)
+	if err != nil {
+		return
+	}
+	for _, parts := range lines {
+		uid, aerr := strconv.Atoi(parts[2])
+		if aerr != nil {
+			err = fmt.Errorf("%s: invalid uid of %s", path, parts[0])
+			return
+		}
+		entries = append(entries, passwdEntry{Name: parts[0], Hash: parts[1], UID: uid, GID: parts[3],
+			Gecos: parts[4], Home: parts[5], Shell: parts[6]})
+	}
+	return
+}
+
+func readGroup(path string) (entries []groupEntry, err error) {
+	lines, err := readColonFile(path, 4)
+	if err != nil {
+		return
+	}
+	for _, parts := range lines {
+		gid, aerr := strconv.Atoi(parts[2])
+		if aerr != nil {
+			err = fmt.Errorf("%s: invalid gid of %s", path, parts[0])
+			return
+		}
+		entry := groupEntry{Name: parts[0], GID: gid}
+		for _, member := range strings.Split(parts[3], ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				entry.Members = append(entry.Members, member)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func readShadow(path string) (entries map[string]shadowEntry, err error) {
+	lines, err := readColonFile(path, 2)
+	if err != nil {
+		return
+	}
+	entries = map[string]shadowEntry{}
+	for _, parts := range lines {
+		entry := shadowEntry{Hash: parts[1], Fields: make([]string, len(shadowAttrs))}
+		copy(entry.Fields, parts[2:])
+		entries[parts[0]] = entry
+	}
+	return
+}
+
+// cryptValue ... userPassword and lock state of a crypt(3) hash from passwd or
+// shadow. ! locks, and *, !! or empty hashes get the never matching {CRYPT}*
+func cryptValue(hash string) (value string, locked bool) {
+	if strings.HasPrefix(hash, "!") {
+		locked = true
+		hash = strings.TrimPrefix(hash, "!")
+	}
+	if hash == "" || hash == "!" || hash == "*" {
+		return "{CRYPT}*", locked
+	}
+	return "{CRYPT}" + hash, locked
+}
+
+// LocalSpec ... desired state of the accounts of local passwd, group and shadow files
+// within the id ranges
+func (lc *LDAPClient) LocalSpec(opts LocalSyncOptions) (spec DirectorySpec, err error) {
+	p := lc.profile()
+	users, err := readPasswd(opts.Passwd)
+	if err != nil {
+		return
+	}
+	var groups []groupEntry
+	if opts.Group != "" {
+		if groups, err = readGroup(opts.Group); err != nil {
+			return
+		}
+	}
+	var shadow map[string]shadowEntry
+	if opts.Shadow != "" {
+		if shadow, err = readShadow(opts.Shadow); err != nil {
+			return
+		}
+	}
+
+	synced := map[string]bool{}
+	for _, user := range users {
+		if user.UID >= opts.UIDMin && user.UID <= opts.UIDMax {
+			synced[user.Name] = true
+		}
+	}
+	userGroups := map[string][]string{}
+	for _, group := range groups {
+		if group.GID < opts.GIDMin || group.GID > opts.GIDMax {
+			continue
+		}
+		spec.Groups = append(spec.Groups, GroupState{Name: group.Name, GIDNumber: strconv.Itoa(group.GID)})
+		for _, member := range group.Members {
+			if synced[member] {
+				userGroups[member] = append(userGroups[member], group.Name)
+			}
+		}
+	}
+
+	shadowClass := containsName(p.UserObjectClasses, "shadowAccount")
+	for _, user := range users {
+		if !synced[user.Name] {
+			continue
+		}
+		state := UserState{
+			Name:       user.Name,
+			UIDNumber:  strconv.Itoa(user.UID),
+			GIDNumber:  user.GID,
+			Groups:     userGroups[user.Name],
+			Attributes: map[string]StringList{"loginShell": {user.Shell}, p.HomeAttr: {user.Home}},
+		}
+		if user.Gecos != "" {
+			state.Attributes["gecos"] = StringList{user.Gecos}
+		}
+		hash := user.Hash
+		entry, ok := shadow[user.Name]
+		if ok {
+			hash = entry.Hash
+		}
+		// x without a shadow file leaves the password alone
+		if ok || hash != "x" {
+			var locked bool
+			state.PasswordHash, locked = cryptValue(hash)
+			state.Locked = &locked
+		}
+		if ok && shadowClass {
+			for i, attr := range shadowAttrs {
+				// the lock state owns shadowExpire when the profile locks with it
+				if attr == LockShadowExpire && p.Lock == LockShadowExpire {
+					continue
+				}
+				if entry.Fields[i] != "" {
+					state.Attributes[attr] = StringList{entry.Fields[i]}
+				}
+			}
+		}
+		spec.Users = append(spec.Users, state)
+	}
+	return
+}
+
+// SyncLocal ... create or update users and groups from local account files
+func SyncLocal(lc *LDAPClient, opts LocalSyncOptions, confirm func(plan []PlanAction) bool) (err error) {
+	spec, err := lc.LocalSpec(opts)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	// synced entries belong to the local files, not to apply --prune
+	return ApplySpec(lc, spec, ApplyOptions{Unmarked: true}, confirm)
+}
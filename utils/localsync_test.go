@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_localSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "userctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"passwd": "root:x:0:0:root:/root:/bin/bash\n" +
+			"alice:x:1000:1000:Alice:/home/alice:/bin/bash\n" +
+			"bob:x:1001:1000::/home/bob:/bin/sh\n",
+		"group": "root:x:0:\nsudo:x:27:alice\nstaff:x:1000:alice,bob,root\n",
+		"shadow": "root:$6$r:17000:0:99999:7:::\n" +
+			"alice:$6$salt$hash:17000:0:99999:7:::\n" +
+			"bob:!$6$salt$bob:17000:0:99999:7::19000:\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lc := &LDAPClient{Profile: ProfileRFC2307}
+	spec, err := lc.LocalSpec(LocalSyncOptions{
+		Passwd: filepath.Join(dir, "passwd"),
+		Group:  filepath.Join(dir, "group"),
+		Shadow: filepath.Join(dir, "shadow"),
+		UIDMin: 1000, UIDMax: 60000, GIDMin: 1000, GIDMax: 60000,
+	})
+	if err != nil {
+		t.Fatalf("error reading local files: %v", err)
+	}
+	if len(spec.Users) != 2 || len(spec.Groups) != 1 || spec.Groups[0].Name != "staff" {
+		t.Fatalf("unexpected spec: %+v", spec)
+	}
+	alice, bob := spec.Users[0], spec.Users[1]
+	if alice.PasswordHash != "{CRYPT}$6$salt$hash" || *alice.Locked || alice.Groups[0] != "staff" {
+		t.Fatalf("unexpected alice: %+v", alice)
+	}
+	if bob.PasswordHash != "{CRYPT}$6$salt$bob" || !*bob.Locked {
+		t.Fatalf("unexpected bob: %+v", bob)
+	}
+	if _, ok := bob.Attributes["shadowExpire"]; ok || bob.Attributes["shadowMax"][0] != "99999" {
+		t.Fatalf("unexpected shadow attributes: %v", bob.Attributes)
+	}
+}
+
+func Test_localSyncPlanUnmarked(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	spec := DirectorySpec{
+		Users: []UserState{
+			{Name: "alice", UIDNumber: "1001", GIDNumber: "100", Attributes: map[string]StringList{"loginShell": {"/bin/zsh"}}},
+			{Name: "erin", UIDNumber: "1005", GIDNumber: "100", PasswordHash: "{CRYPT}$6$salt$hash"},
+		},
+		Groups: []GroupState{{Name: "dev", GIDNumber: "200"}, {Name: "staff", GIDNumber: "300"}},
+	}
+	plan, err := lc.PlanApply(spec, ApplyOptions{Unmarked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, action := range plan {
+		actions = append(actions, action.String())
+	}
+	want := []string{"+ group staff", "~ user alice (loginShell)", "+ user erin"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("unexpected plan: %v", actions)
+	}
+	if err = lc.ApplyPlan(plan); err != nil {
+		t.Fatal(err)
+	}
+	for _, dn := range []string{"uid=erin,ou=People,dc=test,dc=com", "cn=staff,ou=Group,dc=test,dc=com", "uid=alice,ou=People,dc=test,dc=com"} {
+		if entry, ok := s.entry(dn); !ok || len(entry.Values("description")) > 0 {
+			t.Fatalf("missing or marked %s: %v", dn, entry.Attributes)
+		}
+	}
+	marked, err := lc.PlanApply(spec, ApplyOptions{})
+	if err != nil || len(marked) != 4 {
+		t.Fatalf("marker changes missing from the apply plan: %v %v", marked, err)
+	}
+	if _, err = lc.PlanApply(spec, ApplyOptions{Prune: true, Unmarked: true}); err == nil {
+		t.Fatal("unmarked prune accepted")
+	}
+}
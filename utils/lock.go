@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountDisable ... ACCOUNTDISABLE bit of userAccountControl
+const accountDisable = 2
+
+// lockAttrs ... attributes telling whether an account is locked
+func (lc *LDAPClient) lockAttrs() []string {
+	attrs := []string{lc.profile().Lock}
+	if lc.SambaEnabled() {
+		attrs = append(attrs, "sambaAcctFlags")
+	}
+	return attrs
+}
+
+// IsLocked ... whether the user entry, read with lockAttrs, is locked
+func (lc *LDAPClient) IsLocked(entry LdapResult) bool {
+	if flags := entry.Values("sambaAcctFlags"); len(flags) > 0 && strings.Contains(flags[0], "D") {
+		return true
+	}
+	values := entry.Values(lc.profile().Lock)
+	if len(values) == 0 {
+		return false
+	}
+	switch lc.profile().Lock {
+	case LockNsAccountLock:
+		return strings.EqualFold(values[0], "TRUE")
+	case LockUserAccountControl:
+		uac, err := strconv.Atoi(values[0])
+		return err == nil && uac&accountDisable != 0
+	default:
+		days, err := strconv.Atoi(values[0])
+		return err == nil && days >= 0 && int64(days) <= time.Now().Unix()/86400
+	}
+}
+
+// setAcctFlag ... samba account flags like [U ] with flag set or cleared, D is disabled
+func setAcctFlag(flags string, flag rune, on bool) string {
+	inner := strings.Trim(flags, "[] ")
+	inner = strings.Replace(inner, string(flag), "", -1)
+	if on {
+		inner += string(flag)
+	}
+	if inner == "" {
+		inner = "U"
+	}
+	return "[" + inner + " ]"
+}
+
+// lockAttributes ... replacements locking or unlocking the user entry, read with lockAttrs
+func (lc *LDAPClient) lockAttributes(entry LdapResult, locked bool) map[string][]string {
+	attrs := map[string][]string{}
+	switch lc.profile().Lock {
+	case LockNsAccountLock:
+		if locked {
+			attrs[LockNsAccountLock] = []string{"TRUE"}
+		} else {
+			attrs[LockNsAccountLock] = []string{}
+		}
+	case LockUserAccountControl:
+		uac := 512
+		if v := entry.Values(LockUserAccountControl); len(v) > 0 {
+			if n, err := strconv.Atoi(v[0]); err == nil {
+				uac = n
+			}
+		}
+		if locked {
+			uac |= accountDisable
+		} else {
+			uac &^= accountDisable
+		}
+		attrs[LockUserAccountControl] = []string{strconv.Itoa(uac)}
+	default:
+		if locked {
+			attrs[LockShadowExpire] = []string{"1"}
+		} else {
+			attrs[LockShadowExpire] = []string{}
+		}
+	}
+	if lc.SambaEnabled() {
+		flags := "[U ]"
+		if v := entry.Values("sambaAcctFlags"); len(v) > 0 {
+			flags = v[0]
+		}
+		attrs["sambaAcctFlags"] = []string{setAcctFlag(flags, 'D', locked)}
+	}
+	return attrs
+}
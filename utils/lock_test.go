@@ -0,0 +1,12 @@
+package utils
+
+import "testing"
+
+func Test_setAcctFlag(t *testing.T) {
+	if flags := setAcctFlag("[U ]", 'D', true); flags != "[UD ]" {
+		t.Fatalf("unexpected flags: %s", flags)
+	}
+	if flags := setAcctFlag("[UDX        ]", 'D', false); flags != "[UX ]" {
+		t.Fatalf("unexpected flags: %s", flags)
+	}
+}
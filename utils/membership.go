@@ -0,0 +1,592 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Membership styles, selecting the group attributes holding members
+const (
+	// MembershipUID is posixGroup memberUid=<username>
+	MembershipUID = "memberUid"
+	// MembershipDN is RFC2307bis/groupOfNames member=<user dn>
+	MembershipDN = "member"
+	// MembershipUniqueDN is groupOfUniqueNames uniqueMember=<user dn>
+	MembershipUniqueDN = "uniqueMember"
+	// MembershipBoth keeps memberUid and member in sync
+	MembershipBoth = "both"
+)
+
+// memberAttrs ... group attributes written for the membership style
+func (lc *LDAPClient) memberAttrs() []string {
+	membership := lc.Membership
+	if membership == "" {
+		membership = lc.profile().Membership
+	}
+	switch membership {
+	case MembershipDN, MembershipUniqueDN:
+		return []string{membership}
+	case MembershipBoth:
+		return []string{MembershipUID, MembershipDN}
+	}
+	return []string{MembershipUID}
+}
+
+// memberValues ... usernames as values of a member attribute, user dns
+// resolved by login as the rdn need not be the username. A user without an
+// entry, not yet created in a dry run or already deleted, gets UserDn
+func (lc *LDAPClient) memberValues(attr string, usernames []string) (values []string, err error) {
+	if attr == MembershipUID {
+		return usernames, nil
+	}
+	values = make([]string, len(usernames))
+	for i, username := range usernames {
+		data, serr := lc.SearchEntries(lc.userFilter(username), []string{"1.1"}, lc.PeopleDn())
+		if serr != nil {
+			err = serr
+			return
+		}
+		values[i] = lc.UserDn(username)
+		if len(data) > 0 {
+			values[i] = data[0].DN
+		}
+	}
+	return
+}
+
+// memberFilter ... filter matching groups having username as member
+func (lc *LDAPClient) memberFilter(username string) (filter string, err error) {
+	filter = "(|"
+	for _, attr := range lc.memberAttrs() {
+		values, verr := lc.memberValues(attr, []string{username})
+		if verr != nil {
+			return "", verr
+		}
+		for _, v := range values {
+			filter += fmt.Sprintf("(%s=%s)", attr, ldap.EscapeFilter(v))
+		}
+	}
+	return filter + ")", nil
+}
+
+// dnName ... username of a member dn, the login of the user entry it names.
+// The rdn is taken as is for entries right below PeopleDn when it is the
+// login attribute, entries right below GroupDn are groups, and anything else
+// is looked up, so groups and entries that are not users give no name
+func (lc *LDAPClient) dnName(dn string) (name string, ok bool, err error) {
+	p := lc.profile()
+	parsed, perr := ldap.ParseDN(dn)
+	if perr != nil || len(parsed.RDNs) < 2 {
+		return
+	}
+	parent := &ldap.DN{RDNs: parsed.RDNs[1:]}
+	peopleBase, _ := ldap.ParseDN(lc.PeopleDn())
+	groupBase, _ := ldap.ParseDN(lc.GroupDn())
+	samebase := peopleBase != nil && groupBase != nil && peopleBase.Equal(groupBase)
+	if peopleBase != nil && parent.Equal(peopleBase) && strings.EqualFold(p.UserRdnAttr, p.LoginAttr) {
+		for _, attr := range parsed.RDNs[0].Attributes {
+			if strings.EqualFold(attr.Type, p.UserRdnAttr) {
+				return attr.Value, true, nil
+			}
+		}
+	}
+	if groupBase != nil && parent.Equal(groupBase) && !samebase {
+		return
+	}
+	filter := p.UserFilter
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{p.LoginAttr},
+		nil,
+	)
+	sr, err := lc.search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			err = nil
+		}
+		return
+	}
+	for _, entry := range sr.Entries {
+		for _, attr := range entry.Attributes {
+			if strings.EqualFold(attr.Name, p.LoginAttr) && len(attr.Values) > 0 {
+				return attr.Values[0], true, nil
+			}
+		}
+	}
+	return
+}
+
+// memberNames ... usernames listed in any member attribute of a group entry
+func (lc *LDAPClient) memberNames(group LdapResult) (names []string, err error) {
+	seen := map[string]bool{}
+	for _, attr := range lc.memberAttrs() {
+		for _, v := range group.Values(attr) {
+			name := v
+			if attr != MembershipUID {
+				var ok bool
+				if name, ok, err = lc.dnName(v); err != nil {
+					return
+				}
+				if !ok {
+					continue
+				}
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return
+}
+
+// userDetailAttrs ... attributes shown for resolved group members
+func (lc *LDAPClient) userDetailAttrs() []string {
+	p := lc.profile()
+	return []string{p.LoginAttr, "uidNumber", "gidNumber", "cn", "displayName", p.HomeAttr, "loginShell"}
+}
+
+// GroupMember ... member of a group resolved to its user entry
+type GroupMember struct {
+	UID string `json:"uid"`
+	// Dangling is set when no user with this uid exists
+	Dangling bool        `json:"dangling,omitempty"`
+	User     *LdapResult `json:"user,omitempty"`
+	// Via names the nested group the member was found in
+	Via string `json:"via,omitempty"`
+}
+
+// UserGroup ... group a user belongs to
+type UserGroup struct {
+	Name      string `json:"name"`
+	GidNumber string `json:"gidNumber"`
+	// Primary is set for the group matching the gidNumber of the user
+	Primary  bool   `json:"primary,omitempty"`
+	SambaSID string `json:"sambaSID,omitempty"`
+	// Via names the group through which membership is inherited
+	Via string `json:"via,omitempty"`
+}
+
+// MembershipChange ... summary of a membership update
+type MembershipChange struct {
+	Group   string   `json:"group"`
+	Status  string   `json:"status"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	// Unchanged lists users that were already in the requested state
+	Unchanged []string `json:"unchanged,omitempty"`
+}
+
+// JSON ... change as indented json
+func (c MembershipChange) JSON() (data string, err error) {
+	changebytes, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return
+	}
+	data = string(changebytes)
+	return
+}
+
+// ReadNameList ... read names from a file, one per line, skipping blanks and # comments
+func ReadNameList(path string) (names []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	err = scanner.Err()
+	return
+}
+
+// CurrentMembers ... usernames of members of group
+func (lc *LDAPClient) CurrentMembers(groupname string) (members []string, err error) {
+	filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(groupname))
+	data, err := lc.Search(filter, lc.memberAttrs(), lc.GroupDn())
+	if err != nil {
+		return
+	}
+	return lc.memberNames(data[0])
+}
+
+// MissingUsers ... usernames that have no user entry
+func (lc *LDAPClient) MissingUsers(usernames []string) (missing []string) {
+	for _, username := range usernames {
+		filter := lc.userFilter(username)
+		if !lc.ExistUnder(lc.PeopleDn(), filter) {
+			missing = append(missing, username)
+		}
+	}
+	return
+}
+
+// ModifyMembers ... add and remove members of group in a single modify request
+func (lc *LDAPClient) ModifyMembers(groupname string, add []string, del []string) (change MembershipChange, err error) {
+	change = MembershipChange{Group: groupname, Status: StatusUnchanged, Added: []string{}, Removed: []string{}}
+	if missing := lc.MissingUsers(add); len(missing) > 0 {
+		err = fmt.Errorf("username has not existed in ldap: %s", strings.Join(missing, ", "))
+		return
+	}
+	if lc.Idempotent {
+		current, cerr := lc.CurrentMembers(groupname)
+		if cerr != nil {
+			err = cerr
+			return
+		}
+		add, del, change.Unchanged = filterNoops(current, add, del)
+	}
+	if len(add) == 0 && len(del) == 0 {
+		return
+	}
+	if err = lc.applyMembers(groupname, add, del); err != nil {
+		return
+	}
+	change.Status = StatusChanged
+	change.Added = append(change.Added, add...)
+	change.Removed = append(change.Removed, del...)
+	return
+}
+
+// applyMembers ... add and remove members of group
+func (lc *LDAPClient) applyMembers(groupname string, add []string, del []string) error {
+	modify, err := lc.membersRequest(groupname, add, del)
+	if err != nil {
+		return err
+	}
+	if len(modify.AddAttributes) == 0 && len(modify.DeleteAttributes) == 0 {
+		return nil
+	}
+	return lc.Modify(modify)
+}
+
+// membersRequest ... modify request adding and removing members of group.
+// Each member attribute gets only the values it lacks or holds, so with
+// MembershipBoth a user listed in just one of them is still added to the
+// other or removed from it. Adding a user listed in all of them or removing
+// one listed in none is an error
+func (lc *LDAPClient) membersRequest(groupname string, add []string, del []string) (modify *ldap.ModifyRequest, err error) {
+	attrs := lc.memberAttrs()
+	group, err := lc.ReadEntry(lc.GroupEntryDn(groupname), attrs)
+	if err != nil {
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return
+		}
+		// a group created in the same dry run has no members yet
+		err = nil
+	}
+	modify = ldap.NewModifyRequest(lc.GroupEntryDn(groupname))
+	listed := map[string]int{}
+	for _, attr := range attrs {
+		have := map[string]bool{}
+		for _, v := range group.Values(attr) {
+			have[strings.ToLower(v)] = true
+		}
+		addValues, verr := lc.memberValues(attr, add)
+		if verr != nil {
+			return nil, verr
+		}
+		delValues, verr := lc.memberValues(attr, del)
+		if verr != nil {
+			return nil, verr
+		}
+		var adds, dels []string
+		for i, v := range addValues {
+			if have[strings.ToLower(v)] {
+				listed["+"+add[i]]++
+			} else {
+				adds = append(adds, v)
+			}
+		}
+		for i, v := range delValues {
+			if have[strings.ToLower(v)] {
+				listed["-"+del[i]]++
+				dels = append(dels, v)
+			}
+		}
+		if len(adds) > 0 {
+			modify.Add(attr, adds)
+		}
+		if len(dels) > 0 {
+			modify.Delete(attr, dels)
+		}
+	}
+	for _, name := range add {
+		if listed["+"+name] == len(attrs) {
+			return nil, fmt.Errorf("%s is already a member of %s", name, groupname)
+		}
+	}
+	for _, name := range del {
+		if listed["-"+name] == 0 {
+			return nil, fmt.Errorf("%s is not a member of %s", name, groupname)
+		}
+	}
+	return
+}
+
+// memberClasses ... object classes allowing a dn member attribute
+var memberClasses = map[string][]string{
+	MembershipDN:       {"groupOfNames", "group", "groupOfMembers"},
+	MembershipUniqueDN: {"groupOfUniqueNames"},
+}
+
+// withMemberClasses ... classes of a new group plus groupOfNames or
+// groupOfUniqueNames when the membership style writes an attribute none
+// of them allows, as with --membership member on a posixGroup profile
+func (lc *LDAPClient) withMemberClasses(classes []string) []string {
+	for _, attr := range lc.memberAttrs() {
+		allowing := memberClasses[attr]
+		if len(allowing) == 0 {
+			continue
+		}
+		found := false
+		for _, class := range classes {
+			for _, allowed := range allowing {
+				found = found || strings.EqualFold(class, allowed)
+			}
+		}
+		if !found {
+			classes = append(append([]string{}, classes...), allowing[0])
+		}
+	}
+	return classes
+}
+
+// filterNoops ... drop adds of current members and removals of non-members
+func filterNoops(current []string, add []string, del []string) (realAdd []string, realDel []string, noops []string) {
+	have := map[string]bool{}
+	for _, name := range current {
+		have[name] = true
+	}
+	for _, name := range add {
+		if have[name] {
+			noops = append(noops, name)
+		} else {
+			realAdd = append(realAdd, name)
+			have[name] = true
+		}
+	}
+	for _, name := range del {
+		if have[name] {
+			realDel = append(realDel, name)
+			delete(have, name)
+		} else {
+			noops = append(noops, name)
+		}
+	}
+	return
+}
+
+// SetMembers ... make usernames the exact members of group, asking confirm
+// first if that removes members
+func (lc *LDAPClient) SetMembers(groupname string, usernames []string, confirm func(del []string) bool) (change MembershipChange, err error) {
+	current, err := lc.CurrentMembers(groupname)
+	if err != nil {
+		return
+	}
+	add, del := diffNames(current, usernames)
+	if len(del) > 0 && !confirm(del) {
+		err = errors.New("aborted")
+		return
+	}
+	return lc.ModifyMembers(groupname, add, del)
+}
+
+// diffNames ... names to add to and remove from current to get wanted
+func diffNames(current []string, wanted []string) (add []string, del []string) {
+	have := map[string]bool{}
+	for _, name := range current {
+		have[name] = true
+	}
+	want := map[string]bool{}
+	for _, name := range wanted {
+		if !want[name] && !have[name] {
+			add = append(add, name)
+		}
+		want[name] = true
+	}
+	for _, name := range current {
+		if !want[name] {
+			del = append(del, name)
+		}
+	}
+	return
+}
+
+// GroupMembers ... resolve members of group to users
+func (lc *LDAPClient) GroupMembers(groupname string) (members []GroupMember, err error) {
+	current, err := lc.CurrentMembers(groupname)
+	if err != nil {
+		return
+	}
+	members = []GroupMember{}
+	for _, uid := range current {
+		member := GroupMember{UID: uid}
+		filter := lc.userFilter(uid)
+		users, serr := lc.SearchEntries(filter, lc.userDetailAttrs(), lc.PeopleDn())
+		if serr != nil {
+			err = serr
+			return
+		}
+		if len(users) == 0 {
+			member.Dangling = true
+		} else {
+			member.User = &users[0]
+		}
+		members = append(members, member)
+	}
+	return
+}
+
+// UserGroups ... primary group and all groups listing username as member
+func (lc *LDAPClient) UserGroups(username string) (groups []UserGroup, err error) {
+	filter := lc.userFilter(username)
+	users, err := lc.Search(filter, []string{"gidNumber", "memberOf"}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	gidNumber := users[0].Attributes["gidNumber"]
+	if len(gidNumber) == 0 {
+		err = errors.New("user has no gidNumber")
+		return
+	}
+
+	groups = []UserGroup{}
+	primary := UserGroup{GidNumber: gidNumber[0], Primary: true}
+	filter = fmt.Sprintf("(&(cn=*)(gidNumber=%s))", ldap.EscapeFilter(gidNumber[0]))
+	data, err := lc.SearchEntries(filter, []string{"cn", "sambaSID"}, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	if len(data) > 0 {
+		primary.Name = data[0].Attributes["cn"][0]
+		if sid := data[0].Attributes["sambaSID"]; len(sid) > 0 {
+			primary.SambaSID = sid[0]
+		}
+	}
+	groups = append(groups, primary)
+
+	groupAttrs := []string{"cn", "gidNumber", "sambaSID"}
+	memberFilter, err := lc.memberFilter(username)
+	if err != nil {
+		return
+	}
+	data, err = lc.SearchEntries(memberFilter, groupAttrs, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	// with the memberOf overlay, groups outside the group container are listed on the user
+	found := map[string]bool{}
+	for _, entry := range data {
+		found[strings.ToLower(entry.DN)] = true
+	}
+	for _, dn := range users[0].Values("memberOf") {
+		if found[strings.ToLower(dn)] {
+			continue
+		}
+		found[strings.ToLower(dn)] = true
+		entries, serr := lc.SearchEntries("(objectClass=*)", groupAttrs, dn)
+		if serr != nil {
+			err = serr
+			return
+		}
+		if len(entries) > 0 {
+			data = append(data, entries[0])
+		}
+	}
+	others := []UserGroup{}
+	for _, entry := range data {
+		group := UserGroup{}
+		if cn := entry.Attributes["cn"]; len(cn) > 0 {
+			group.Name = cn[0]
+		}
+		if gid := entry.Attributes["gidNumber"]; len(gid) > 0 {
+			group.GidNumber = gid[0]
+		}
+		if sid := entry.Attributes["sambaSID"]; len(sid) > 0 {
+			group.SambaSID = sid[0]
+		}
+		if group.GidNumber == primary.GidNumber {
+			continue
+		}
+		others = append(others, group)
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].Name < others[j].Name })
+	groups = append(groups, others...)
+	return
+}
+
+// GroupMembers ... get members of group with user details
+func GroupMembers(lc *LDAPClient, name string, recursive bool) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	var members []GroupMember
+	if recursive {
+		members, err = lc.ExpandMembers(name)
+	} else {
+		members, err = lc.GroupMembers(name)
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	membersbytes, err := json.MarshalIndent(members, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(membersbytes)
+	return
+}
+
+// UserGroups ... get groups of user
+func UserGroups(lc *LDAPClient, name string, effective bool) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	var groups []UserGroup
+	if effective {
+		groups, err = lc.EffectiveGroups(name)
+	} else {
+		groups, err = lc.UserGroups(name)
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	groupsbytes, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(groupsbytes)
+	return
+}
@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_diffNames(t *testing.T) {
+	add, del := diffNames([]string{"alice", "bob", "carol"}, []string{"bob", "dave", "dave", "alice"})
+	if !reflect.DeepEqual(add, []string{"dave"}) {
+		t.Fatalf("unexpected add: %v", add)
+	}
+	if !reflect.DeepEqual(del, []string{"carol"}) {
+		t.Fatalf("unexpected del: %v", del)
+	}
+}
+
+func Test_filterNoops(t *testing.T) {
+	add, del, noops := filterNoops([]string{"alice", "bob"}, []string{"alice", "carol"}, []string{"bob", "dave"})
+	if !reflect.DeepEqual(add, []string{"carol"}) || !reflect.DeepEqual(del, []string{"bob"}) {
+		t.Fatalf("unexpected add %v, del %v", add, del)
+	}
+	if !reflect.DeepEqual(noops, []string{"alice", "dave"}) {
+		t.Fatalf("unexpected noops: %v", noops)
+	}
+}
+
+func Test_memberNames(t *testing.T) {
+	lc := &LDAPClient{BaseDn: "dc=test,dc=com", Membership: MembershipBoth}
+	group := LdapResult{
+		DN: "cn=dev,ou=Group,dc=test,dc=com",
+		Attributes: map[string][]string{
+			"memberUid": {"alice"},
+			"member":    {"uid=alice,ou=People,dc=test,dc=com", "uid=bob,ou=People,dc=test,dc=com", "cn=ops,ou=Group,dc=test,dc=com"},
+		},
+	}
+	names, err := lc.memberNames(group)
+	if err != nil || !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+		t.Fatalf("unexpected names: %v %v", names, err)
+	}
+
+	s := newFakeServer(t,
+		fixture("dc=test,dc=com", "objectClass", "domain"),
+		fixture("ou=People,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("uid=bob,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "bob"),
+	)
+	defer s.Close()
+	lc.Addr = s.Addr()
+	if err = lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+	if filter, _ := lc.memberFilter("bob"); filter != "(|(memberUid=bob)(member=uid=bob,ou=People,dc=test,dc=com))" {
+		t.Fatalf("unexpected filter: %s", filter)
+	}
+}
+
+// adFixture ... AD shaped users and groups, where cn is the display name
+// and the login is sAMAccountName
+func adFixture() []LdapResult {
+	return []LdapResult{
+		fixture("dc=corp,dc=com", "objectClass", "domain"),
+		fixture("cn=Users,dc=corp,dc=com", "objectClass", "container"),
+		fixture("cn=Alice Smith,cn=Users,dc=corp,dc=com", "objectClass", "user", "objectCategory", "person",
+			"cn", "Alice Smith", "sAMAccountName", "alice", "gidNumber", "100"),
+		fixture("cn=Bob Jones,cn=Users,dc=corp,dc=com", "objectClass", "user", "objectCategory", "person",
+			"cn", "Bob Jones", "sAMAccountName", "bob", "gidNumber", "100"),
+		fixture("cn=ops,cn=Users,dc=corp,dc=com", "objectClass", "group", "cn", "ops", "sAMAccountName", "ops", "gidNumber", "200"),
+		fixture("cn=dev,cn=Users,dc=corp,dc=com", "objectClass", "group", "cn", "dev", "sAMAccountName", "dev",
+			"member", "cn=Alice Smith,cn=Users,dc=corp,dc=com", "member", "cn=ops,cn=Users,dc=corp,dc=com"),
+	}
+}
+
+func Test_activeDirectoryDns(t *testing.T) {
+	s := newFakeServer(t, adFixture()...)
+	defer s.Close()
+	lc := s.client("dc=corp,dc=com", ProfileActiveDirectory)
+	lc.NoSamba = true
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	if dn, err := lc.UserEntryDn("alice"); err != nil || dn != "cn=Alice Smith,cn=Users,dc=corp,dc=com" {
+		t.Fatalf("unexpected user dn: %s %v", dn, err)
+	}
+	members, err := lc.CurrentMembers("dev")
+	if err != nil || !reflect.DeepEqual(members, []string{"alice"}) {
+		t.Fatalf("nested group or cn taken as username: %v %v", members, err)
+	}
+	if _, err = lc.ModifyMembers("dev", []string{"bob"}, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	group, _ := s.entry("cn=dev,cn=Users,dc=corp,dc=com")
+	if want := []string{"cn=ops,cn=Users,dc=corp,dc=com", "cn=Bob Jones,cn=Users,dc=corp,dc=com"}; !reflect.DeepEqual(group.Values("member"), want) {
+		t.Fatalf("unexpected member values: %v", group.Values("member"))
+	}
+	if err = lc.ModifyUser("bob", map[string][]string{"gidNumber": {"200"}}); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := s.entry("cn=Bob Jones,cn=Users,dc=corp,dc=com"); !reflect.DeepEqual(user.Values("gidNumber"), []string{"200"}) {
+		t.Fatalf("modify missed the user: %v", user.Attributes)
+	}
+	if err = lc.TrashUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.entry("cn=Bob Jones,ou=Deleted,dc=corp,dc=com"); !ok {
+		t.Fatal("user not moved to trash")
+	}
+	if err = lc.RestoreUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err = lc.DelUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.entry("cn=Bob Jones,cn=Users,dc=corp,dc=com"); ok {
+		t.Fatal("user not deleted")
+	}
+	if group, _ = s.entry("cn=dev,cn=Users,dc=corp,dc=com"); len(group.Values("member")) != 1 {
+		t.Fatalf("deleted user still a member: %v", group.Values("member"))
+	}
+}
+
+func Test_groupDnName(t *testing.T) {
+	s := newFakeServer(t, nestedFixture(map[string][]string{"ops": nil})...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	lc.Membership = MembershipDN
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	if name, ok, err := lc.groupDnName("cn=ops,ou=Group,dc=test,dc=com"); err != nil || !ok || name != "ops" {
+		t.Fatalf("unexpected group name: %s %v %v", name, ok, err)
+	}
+	for _, dn := range []string{"uid=alice,ou=People,dc=test,dc=com", "cn=gone,ou=Group,dc=test,dc=com"} {
+		if _, ok, err := lc.groupDnName(dn); ok || err != nil {
+			t.Fatalf("%s taken as group: %v", dn, err)
+		}
+	}
+}
+
+func Test_activeDirectoryUsersAndGroups(t *testing.T) {
+	// carol is only the account name of a group, below cn=Users like the users
+	s := newFakeServer(t, append(adFixture(),
+		fixture("cn=carol,cn=Users,dc=corp,dc=com", "objectClass", "group", "cn", "carol", "sAMAccountName", "carol"))...)
+	defer s.Close()
+	lc := s.client("dc=corp,dc=com", ProfileActiveDirectory)
+	lc.NoSamba = true
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	if dn, err := lc.UserEntryDn("carol"); err == nil {
+		t.Fatalf("group taken as user: %s", dn)
+	}
+	if err := lc.TrashUser("carol"); err == nil {
+		t.Fatal("group trashed as user")
+	}
+	captureStdout(t, func() {
+		if err := lc.DelUser("carol"); err == nil {
+			t.Fatal("group deleted as user")
+		}
+	})
+	if _, ok := s.entry("cn=carol,cn=Users,dc=corp,dc=com"); !ok || len(s.Writes()) > 0 {
+		t.Fatalf("group entry written: %v", s.Writes())
+	}
+
+	if _, ok, err := lc.groupDnName("cn=Alice Smith,cn=Users,dc=corp,dc=com"); ok || err != nil {
+		t.Fatalf("user taken as group: %v", err)
+	}
+	if subgroups, err := lc.SubGroups("dev"); err != nil || !reflect.DeepEqual(subgroups, []string{"ops"}) {
+		t.Fatalf("unexpected subgroups: %v %v", subgroups, err)
+	}
+}
+
+// posixFixture ... rfc2307 users alice, bob and carol, dev listing alice
+// only in memberUid and bob only in member
+func posixFixture() []LdapResult {
+	return []LdapResult{
+		fixture("dc=test,dc=com", "objectClass", "domain"),
+		fixture("ou=People,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("ou=Group,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("uid=alice,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "alice", "uidNumber", "1001", "gidNumber", "100"),
+		fixture("uid=bob,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "bob", "uidNumber", "1002", "gidNumber", "100"),
+		fixture("uid=carol,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "carol", "uidNumber", "1003", "gidNumber", "100"),
+		fixture("cn=dev,ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "cn", "dev", "gidNumber", "200",
+			"memberUid", "alice", "member", "uid=bob,ou=People,dc=test,dc=com"),
+	}
+}
+
+func Test_membersRequestMixed(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	lc.Membership = MembershipBoth
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	if _, err := lc.ModifyMembers("dev", []string{"bob", "carol"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	group, _ := s.entry("cn=dev,ou=Group,dc=test,dc=com")
+	if want := []string{"alice", "bob", "carol"}; !reflect.DeepEqual(group.Values("memberUid"), want) {
+		t.Fatalf("unexpected memberUid: %v", group.Values("memberUid"))
+	}
+	if want := []string{"uid=bob,ou=People,dc=test,dc=com", "uid=carol,ou=People,dc=test,dc=com"}; !reflect.DeepEqual(group.Values("member"), want) {
+		t.Fatalf("unexpected member: %v", group.Values("member"))
+	}
+	if err := lc.DelUser("alice"); err != nil {
+		t.Fatalf("user listed in memberUid only not deleted: %v", err)
+	}
+	if _, err := lc.ModifyMembers("dev", []string{"bob"}, nil); err == nil {
+		t.Fatal("re-adding a full member succeeded")
+	}
+	if _, err := lc.ModifyMembers("dev", nil, []string{"alice"}); err == nil {
+		t.Fatal("removing a non-member succeeded")
+	}
+}
+
+func Test_withMemberClasses(t *testing.T) {
+	lc := &LDAPClient{Profile: ProfileRFC2307, Membership: MembershipDN}
+	if classes := lc.withMemberClasses(ProfileRFC2307.GroupObjectClasses); !reflect.DeepEqual(classes, []string{"top", "posixGroup", "groupOfNames"}) {
+		t.Fatalf("unexpected classes: %v", classes)
+	}
+	if !reflect.DeepEqual(ProfileRFC2307.GroupObjectClasses, []string{"top", "posixGroup"}) {
+		t.Fatal("profile classes changed")
+	}
+	lc = &LDAPClient{Profile: ProfileActiveDirectory}
+	if classes := lc.withMemberClasses(ProfileActiveDirectory.GroupObjectClasses); !reflect.DeepEqual(classes, []string{"top", "group"}) {
+		t.Fatalf("unexpected classes: %v", classes)
+	}
+}
+
+func Test_setMembersConfirm(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	lc.Membership = MembershipBoth
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	var asked []string
+	refuse := func(del []string) bool {
+		asked = del
+		return false
+	}
+	if _, err := lc.SetMembers("dev", []string{"alice", "carol"}, refuse); err == nil || err.Error() != "aborted" {
+		t.Fatalf("removal not refused: %v", err)
+	}
+	if !reflect.DeepEqual(asked, []string{"bob"}) || len(s.Writes()) != 0 {
+		t.Fatalf("unexpected removals %v, writes %v", asked, s.Writes())
+	}
+	asked = nil
+	if _, err := lc.SetMembers("dev", []string{"alice", "bob", "carol"}, refuse); err != nil || asked != nil {
+		t.Fatalf("confirm asked without removals: %v %v", asked, err)
+	}
+	if _, err := lc.SetMembers("dev", []string{"carol"}, func([]string) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if members, _ := lc.CurrentMembers("dev"); !reflect.DeepEqual(members, []string{"carol"}) {
+		t.Fatalf("unexpected members: %v", members)
+	}
+}
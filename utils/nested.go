@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// dnMemberAttrs ... member attributes holding dns, which may name groups
+func (lc *LDAPClient) dnMemberAttrs() (attrs []string) {
+	for _, attr := range lc.memberAttrs() {
+		if attr != MembershipUID {
+			attrs = append(attrs, attr)
+		}
+	}
+	return
+}
+
+// groupDnName ... group name of a member dn directly below the group
+// container, if the entry matches the profile's GroupFilter. Active Directory
+// keeps users there as well
+func (lc *LDAPClient) groupDnName(dn string) (name string, ok bool, err error) {
+	parsed, perr := ldap.ParseDN(dn)
+	if perr != nil || len(parsed.RDNs) < 2 {
+		return
+	}
+	parent := &ldap.DN{RDNs: parsed.RDNs[1:]}
+	groupBase, perr := ldap.ParseDN(lc.GroupDn())
+	if perr != nil || !parent.Equal(groupBase) {
+		return
+	}
+	filter := lc.profile().GroupFilter
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{"cn"},
+		nil,
+	)
+	sr, err := lc.search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			err = nil
+		}
+		return
+	}
+	for _, entry := range sr.Entries {
+		if cn := entry.GetAttributeValue("cn"); cn != "" {
+			return cn, true, nil
+		}
+	}
+	return
+}
+
+// SubGroups ... names of groups that are direct members of group
+func (lc *LDAPClient) SubGroups(groupname string) (subgroups []string, err error) {
+	attrs := lc.dnMemberAttrs()
+	if len(attrs) == 0 {
+		return
+	}
+	filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(groupname))
+	data, err := lc.Search(filter, attrs, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	for _, attr := range attrs {
+		for _, dn := range data[0].Values(attr) {
+			name, ok, gerr := lc.groupDnName(dn)
+			if gerr != nil {
+				err = gerr
+				return
+			}
+			if ok && !seen[name] {
+				seen[name] = true
+				subgroups = append(subgroups, name)
+			}
+		}
+	}
+	return
+}
+
+// NestedGroups ... all groups reachable from group through nested membership,
+// each mapped to the group it was found in. A group met twice on one path is a cycle.
+func (lc *LDAPClient) NestedGroups(groupname string) (via map[string]string, order []string, cycles []string, err error) {
+	via = map[string]string{}
+	visited := map[string]bool{groupname: true}
+	queue := []string{groupname}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		subgroups, serr := lc.SubGroups(current)
+		if serr != nil {
+			err = serr
+			return
+		}
+		for _, sub := range subgroups {
+			if visited[sub] {
+				if lc.reaches(sub, current, via, groupname) {
+					cycles = append(cycles, fmt.Sprintf("%s -> %s", current, sub))
+				}
+				continue
+			}
+			visited[sub] = true
+			via[sub] = current
+			order = append(order, sub)
+			queue = append(queue, sub)
+		}
+	}
+	return
+}
+
+// reaches ... whether from is an ancestor of to in the via tree rooted at root
+func (lc *LDAPClient) reaches(from string, to string, via map[string]string, root string) bool {
+	for name := to; ; {
+		if name == from {
+			return true
+		}
+		if name == root {
+			return false
+		}
+		name = via[name]
+	}
+}
+
+// ExpandMembers ... members of group including members of nested groups
+func (lc *LDAPClient) ExpandMembers(groupname string) (members []GroupMember, err error) {
+	members, err = lc.GroupMembers(groupname)
+	if err != nil {
+		return
+	}
+	_, order, cycles, err := lc.NestedGroups(groupname)
+	if err != nil {
+		return
+	}
+	for _, cycle := range cycles {
+		fmt.Fprintf(os.Stderr, "WARN: group cycle %s\n", cycle)
+	}
+	seen := map[string]bool{}
+	for _, member := range members {
+		seen[member.UID] = true
+	}
+	for _, sub := range order {
+		nested, gerr := lc.GroupMembers(sub)
+		if gerr != nil {
+			err = gerr
+			return
+		}
+		for _, member := range nested {
+			if seen[member.UID] {
+				continue
+			}
+			seen[member.UID] = true
+			member.Via = sub
+			members = append(members, member)
+		}
+	}
+	return
+}
+
+// EffectiveGroups ... groups of user including groups containing them through nesting
+func (lc *LDAPClient) EffectiveGroups(username string) (groups []UserGroup, err error) {
+	groups, err = lc.UserGroups(username)
+	if err != nil {
+		return
+	}
+	attrs := lc.dnMemberAttrs()
+	if len(attrs) == 0 {
+		return
+	}
+	visited := map[string]bool{}
+	queue := []string{}
+	for _, group := range groups {
+		if group.Name != "" {
+			visited[group.Name] = true
+			queue = append(queue, group.Name)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		filter := "(|"
+		for _, attr := range attrs {
+			filter += fmt.Sprintf("(%s=%s)", attr, ldap.EscapeFilter(lc.GroupEntryDn(current)))
+		}
+		filter += ")"
+		data, serr := lc.SearchEntries(filter, []string{"cn", "gidNumber", "sambaSID"}, lc.GroupDn())
+		if serr != nil {
+			err = serr
+			return
+		}
+		for _, entry := range data {
+			group := UserGroup{Via: current}
+			if cn := entry.Values("cn"); len(cn) > 0 {
+				group.Name = cn[0]
+			}
+			if visited[group.Name] {
+				continue
+			}
+			visited[group.Name] = true
+			if gid := entry.Values("gidNumber"); len(gid) > 0 {
+				group.GidNumber = gid[0]
+			}
+			if sid := entry.Values("sambaSID"); len(sid) > 0 {
+				group.SambaSID = sid[0]
+			}
+			groups = append(groups, group)
+			queue = append(queue, group.Name)
+		}
+	}
+	return
+}
+
+// AddSubgroups ... add groups as members of group, refusing cycles
+func (lc *LDAPClient) AddSubgroups(groupname string, subgroups []string) (change MembershipChange, err error) {
+	change = MembershipChange{Group: groupname, Status: StatusUnchanged, Added: []string{}, Removed: []string{}}
+	attrs := lc.dnMemberAttrs()
+	if len(attrs) == 0 {
+		err = errors.New("nested groups need dn based membership (--membership member, uniqueMember or both)")
+		return
+	}
+	current, err := lc.SubGroups(groupname)
+	if err != nil {
+		return
+	}
+	add := []string{}
+	for _, sub := range subgroups {
+		filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(sub))
+		if !lc.ExistUnder(lc.GroupDn(), filter) {
+			err = fmt.Errorf("group %s has not existed in ldap", sub)
+			return
+		}
+		if sub == groupname {
+			err = fmt.Errorf("group %s cannot be a member of itself", sub)
+			return
+		}
+		via, _, _, nerr := lc.NestedGroups(sub)
+		if nerr != nil {
+			err = nerr
+			return
+		}
+		if _, ok := via[groupname]; ok {
+			err = fmt.Errorf("adding %s to %s would create a cycle", sub, groupname)
+			return
+		}
+		if lc.Idempotent && containsName(current, sub) {
+			change.Unchanged = append(change.Unchanged, sub)
+			continue
+		}
+		add = append(add, sub)
+	}
+	if len(add) == 0 {
+		return
+	}
+	dns := make([]string, len(add))
+	for i, sub := range add {
+		dns[i] = lc.GroupEntryDn(sub)
+	}
+	modify := ldap.NewModifyRequest(lc.GroupEntryDn(groupname))
+	for _, attr := range attrs {
+		modify.Add(attr, dns)
+	}
+	if err = lc.Modify(modify); err != nil {
+		return
+	}
+	change.Status = StatusChanged
+	change.Added = add
+	return
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupAddSubgroups ... add groups to group
+func GroupAddSubgroups(lc *LDAPClient, groupname string, subgroups []string) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	change, err := lc.AddSubgroups(groupname, subgroups)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return change.JSON()
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// nestedFixture ... users alice and bob, and groups whose members are the
+// names given, users or groups
+func nestedFixture(groups map[string][]string) []LdapResult {
+	entries := []LdapResult{
+		fixture("dc=test,dc=com", "objectClass", "domain"),
+		fixture("ou=People,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("ou=Group,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("uid=alice,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "alice", "gidNumber", "100"),
+		fixture("uid=bob,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "bob", "gidNumber", "100"),
+	}
+	gid := 200
+	for _, name := range sortedAttrNames(groups) {
+		group := fixture("cn="+name+",ou=Group,dc=test,dc=com", "objectClass", "posixGroup", "objectClass", "groupOfNames", "cn", name)
+		group.Attributes["gidNumber"] = []string{strconv.Itoa(gid)}
+		for _, member := range groups[name] {
+			if member == "alice" || member == "bob" {
+				member = "uid=" + member + ",ou=People,dc=test,dc=com"
+			} else {
+				member = "cn=" + member + ",ou=Group,dc=test,dc=com"
+			}
+			group.Attributes["member"] = append(group.Attributes["member"], member)
+		}
+		entries = append(entries, group)
+		gid += 100
+	}
+	return entries
+}
+
+func Test_nestedGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups map[string][]string
+		// order and cycles of NestedGroups("a")
+		order  []string
+		cycles []string
+		// ExpandMembers("a") as uid@via
+		members []string
+		// EffectiveGroups("alice") as name@via, without the primary group
+		effective []string
+	}{
+		{
+			name:      "a b a",
+			groups:    map[string][]string{"a": {"b", "alice"}, "b": {"a", "bob"}},
+			order:     []string{"b"},
+			cycles:    []string{"b -> a"},
+			members:   []string{"alice@", "bob@b"},
+			effective: []string{"a@", "b@a"},
+		},
+		{
+			name:      "self",
+			groups:    map[string][]string{"a": {"a", "alice"}},
+			cycles:    []string{"a -> a"},
+			members:   []string{"alice@"},
+			effective: []string{"a@"},
+		},
+		{
+			name:      "diamond",
+			groups:    map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d", "bob"}, "d": {"alice"}},
+			order:     []string{"b", "c", "d"},
+			members:   []string{"bob@c", "alice@d"},
+			effective: []string{"d@", "b@d", "c@d", "a@b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, nestedFixture(tt.groups)...)
+			defer s.Close()
+			lc := s.client("dc=test,dc=com", ProfileRFC2307)
+			lc.Membership = MembershipDN
+			if err := lc.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer lc.Close()
+
+			via, order, cycles, err := lc.NestedGroups("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(order, tt.order) || !reflect.DeepEqual(cycles, tt.cycles) {
+				t.Fatalf("unexpected order %v, cycles %v", order, cycles)
+			}
+			for _, sub := range order {
+				if !lc.reaches("a", sub, via, "a") {
+					t.Fatalf("%s not reached from a", sub)
+				}
+			}
+
+			expanded, err := lc.ExpandMembers("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var members []string
+			for _, member := range expanded {
+				members = append(members, member.UID+"@"+member.Via)
+			}
+			if !reflect.DeepEqual(members, tt.members) {
+				t.Fatalf("unexpected members: %v", members)
+			}
+
+			groups, err := lc.EffectiveGroups("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var effective []string
+			for _, group := range groups[1:] {
+				effective = append(effective, group.Name+"@"+group.Via)
+			}
+			if !reflect.DeepEqual(effective, tt.effective) {
+				t.Fatalf("unexpected effective groups: %v", effective)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Defaults of PoolOptions
+const (
+	DefaultPoolSize    = 4
+	DefaultHealthCheck = 30 * time.Second
+)
+
+// ErrPoolClosed ... Get after Close
+var ErrPoolClosed = errors.New("ldap pool is closed")
+
+// PoolOptions ... size and health checking of a Pool
+type PoolOptions struct {
+	// Size is the most connections open at once, Get blocks beyond it
+	Size int
+	// HealthCheck is how long a connection may sit idle before it is checked
+	// with a rootDSE search on its next Get
+	HealthCheck time.Duration
+}
+
+// idleClient ... pooled client and when it was returned
+type idleClient struct {
+	lc       *LDAPClient
+	returned time.Time
+}
+
+// Pool ... bound connections shared by goroutines. Each Get hands out a client
+// for exclusive use, on which Connect and Close are no-ops, so the package
+// level helpers like GetUsers or AddUser reuse its connection:
+//
+//	pool := utils.NewPool(client, utils.PoolOptions{})
+//	defer pool.Close()
+//	err := pool.Do(func(lc *utils.LDAPClient) error {
+//		_, err := utils.GroupAddMembers(lc, "dev", []string{"alice"})
+//		return err
+//	})
+type Pool struct {
+	client LDAPClient
+	opts   PoolOptions
+	slots  chan struct{}
+	dial   func() (*LDAPClient, error)
+
+	mu     sync.Mutex
+	idle   []idleClient
+	closed bool
+}
+
+// NewPool ... pool of connections with the settings of lc
+func NewPool(lc *LDAPClient, opts PoolOptions) *Pool {
+	if opts.Size <= 0 {
+		opts.Size = DefaultPoolSize
+	}
+	if opts.HealthCheck <= 0 {
+		opts.HealthCheck = DefaultHealthCheck
+	}
+	p := &Pool{client: *lc, opts: opts, slots: make(chan struct{}, opts.Size)}
+	p.client.Conn = nil
+	p.client.writeConn = nil
+	p.client.journal = nil
+	p.client.unwatch = nil
+	p.dial = p.connect
+	return p
+}
+
+// connect ... new bound client with the pool settings
+func (p *Pool) connect() (lc *LDAPClient, err error) {
+	client := p.client
+	if err = client.Connect(); err != nil {
+		return
+	}
+	client.pooled = true
+	return &client, nil
+}
+
+// IsNetworkError ... err means the connection is gone, not that the request failed
+func IsNetworkError(err error) bool {
+	return err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork)
+}
+
+// healthy ... whether the connections of lc still answer a rootDSE search
+func healthy(lc *LDAPClient) bool {
+	if lc.Conn == nil {
+		return false
+	}
+	for _, conn := range []*ldap.Conn{lc.Conn, lc.writeConn} {
+		if conn == nil {
+			continue
+		}
+		searchRequest := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(objectClass=*)", []string{"1.1"}, nil)
+		if _, err := conn.Search(searchRequest); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Get ... a connected client for exclusive use, to be returned with Put.
+// Idle connections are reused, checked first if idle longer than HealthCheck,
+// and replaced by a new connection and bind if the check fails
+func (p *Pool) Get() (lc *LDAPClient, err error) {
+	p.slots <- struct{}{}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrPoolClosed
+	}
+	var idle idleClient
+	if n := len(p.idle); n > 0 {
+		idle = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if idle.lc != nil {
+		if time.Since(idle.returned) < p.opts.HealthCheck || healthy(idle.lc) {
+			return idle.lc, nil
+		}
+		idle.lc.disconnect()
+	}
+	if lc, err = p.dial(); err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return
+}
+
+// Put ... return a client of Get with the last error it returned. After an
+// error the client is checked, since not every error of a lost connection is
+// a network error, and closed if the check fails, the next Get connects and
+// binds again
+func (p *Pool) Put(lc *LDAPClient, err error) {
+	lc.journal = nil
+	if err != nil && !healthy(lc) {
+		lc.disconnect()
+		<-p.slots
+		return
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		lc.disconnect()
+	} else {
+		p.idle = append(p.idle, idleClient{lc: lc, returned: time.Now()})
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// Do ... run fn with a pooled client. fn is run once more on a new connection
+// if it fails with a network error, so it should be safe to repeat
+func (p *Pool) Do(fn func(lc *LDAPClient) error) (err error) {
+	for attempt := 0; ; attempt++ {
+		lc, gerr := p.Get()
+		if gerr != nil {
+			return gerr
+		}
+		err = fn(lc)
+		p.Put(lc, err)
+		if attempt > 0 || !IsNetworkError(err) {
+			return
+		}
+	}
+}
+
+// Close ... close the idle connections, clients in use are closed when put back
+func (p *Pool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+	for _, client := range idle {
+		client.lc.disconnect()
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+func Test_poolReuse(t *testing.T) {
+	s := newFakeServer(t, posixFixture()...)
+	defer s.Close()
+	p := NewPool(s.client("dc=test,dc=com", ProfileRFC2307), PoolOptions{Size: 1})
+	dialed := 0
+	p.dial = func() (*LDAPClient, error) {
+		dialed++
+		return p.connect()
+	}
+
+	first, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(first, errors.New("no such object"))
+	second, _ := p.Get()
+	if second != first || dialed != 1 {
+		t.Fatalf("healthy client not reused, dialed %d", dialed)
+	}
+	// a dropped connection need not surface as a network error
+	second.Conn.Close()
+	p.Put(second, errors.New("user bob has not existed in ldap"))
+	third, _ := p.Get()
+	if third == second || dialed != 2 {
+		t.Fatalf("client with dropped connection reused, dialed %d", dialed)
+	}
+	p.Put(third, nil)
+
+	attempts := 0
+	p.dial = func() (*LDAPClient, error) {
+		dialed++
+		return &LDAPClient{Addr: p.client.Addr, pooled: true}, nil
+	}
+	err = p.Do(func(lc *LDAPClient) error {
+		attempts++
+		return ldap.NewError(ldap.ErrorNetwork, errors.New("ldap: connection closed"))
+	})
+	if !IsNetworkError(err) || attempts != 2 {
+		t.Fatalf("unexpected retry: %d attempts, %v", attempts, err)
+	}
+
+	p.Close()
+	if _, err = p.Get(); err != ErrPoolClosed {
+		t.Fatalf("expected closed pool, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Password handling of a profile
+const (
+	// PasswordExop sets userPassword and then uses the password modify extended operation
+	PasswordExop = "exop"
+	// PasswordAttr writes the clear text to userPassword and lets the server hash it
+	PasswordAttr = "userPassword"
+	// PasswordUnicodePwd writes the quoted UTF-16LE password to unicodePwd (Active Directory)
+	PasswordUnicodePwd = "unicodePwd"
+)
+
+// Account locking of a profile
+const (
+	// LockShadowExpire expires the account with shadowExpire: 1
+	LockShadowExpire = "shadowExpire"
+	// LockNsAccountLock sets nsAccountLock: TRUE (389-DS, FreeIPA)
+	LockNsAccountLock = "nsAccountLock"
+	// LockUserAccountControl sets the ACCOUNTDISABLE bit of userAccountControl (Active Directory)
+	LockUserAccountControl = "userAccountControl"
+)
+
+// Profile ... how users and groups are laid out in a directory schema
+type Profile struct {
+	Name string
+	// PeopleRdn and GroupRdn are the containers of users and groups below the basedn
+	PeopleRdn string
+	GroupRdn  string
+	// UserRdnAttr names user entries, LoginAttr holds the login name
+	UserRdnAttr string
+	LoginAttr   string
+	// GroupLoginAttr, if set, also holds the group name (sAMAccountName)
+	GroupLoginAttr     string
+	HomeAttr           string
+	UserObjectClasses  []string
+	GroupObjectClasses []string
+	// UserFilter and GroupFilter select entries when listing
+	UserFilter  string
+	GroupFilter string
+	// UserAttrs and GroupAttrs are fixed attributes added on creation
+	UserAttrs  map[string][]string
+	GroupAttrs map[string][]string
+	Membership string
+	Password   string
+	// Lock is how accounts are locked, see LockShadowExpire
+	Lock string
+	// Samba adds sambaSamAccount and sambaGroupMapping attributes when the
+	// server has the samba schema, see LDAPClient.SambaEnabled
+	Samba bool
+}
+
+// Built-in profiles
+var (
+	// ProfileRFC2307 is plain posixAccount/posixGroup
+	ProfileRFC2307 = &Profile{
+		Name:               "rfc2307",
+		PeopleRdn:          "ou=People",
+		GroupRdn:           "ou=Group",
+		UserRdnAttr:        "uid",
+		LoginAttr:          "uid",
+		HomeAttr:           "homeDirectory",
+		UserObjectClasses:  []string{"top", "person", "organizationalPerson", "inetOrgPerson", "posixAccount", "shadowAccount"},
+		GroupObjectClasses: []string{"top", "posixGroup"},
+		UserFilter:         "(objectClass=posixAccount)",
+		GroupFilter:        "(objectClass=posixGroup)",
+		UserAttrs:          map[string][]string{"shadowMin": {"0"}},
+		Membership:         MembershipUID,
+		Password:           PasswordExop,
+		Lock:               LockShadowExpire,
+	}
+	// ProfileOpenLDAPSamba is OpenLDAP with the samba schema, the default
+	ProfileOpenLDAPSamba = &Profile{
+		Name:        "openldap-samba",
+		PeopleRdn:   "ou=People",
+		GroupRdn:    "ou=Group",
+		UserRdnAttr: "uid",
+		LoginAttr:   "uid",
+		HomeAttr:    "homeDirectory",
+		UserObjectClasses: []string{"top", "person", "organizationalPerson",
+			"inetOrgPerson", "sambaSamAccount", "posixAccount", "shadowAccount"},
+		GroupObjectClasses: []string{"top", "posixGroup", "sambaGroupMapping"},
+		UserFilter:         "(objectClass=posixAccount)",
+		GroupFilter:        "(objectClass=posixGroup)",
+		UserAttrs:          map[string][]string{"shadowMin": {"0"}},
+		Membership:         MembershipUID,
+		Password:           PasswordExop,
+		Lock:               LockShadowExpire,
+		Samba:              true,
+	}
+	// Profile389DS is 389 Directory Server with RFC2307bis groups
+	Profile389DS = &Profile{
+		Name:               "389ds",
+		PeopleRdn:          "ou=People",
+		GroupRdn:           "ou=Groups",
+		UserRdnAttr:        "uid",
+		LoginAttr:          "uid",
+		HomeAttr:           "homeDirectory",
+		UserObjectClasses:  []string{"top", "person", "organizationalPerson", "inetOrgPerson", "posixAccount"},
+		GroupObjectClasses: []string{"top", "groupOfNames", "posixGroup"},
+		UserFilter:         "(objectClass=posixAccount)",
+		GroupFilter:        "(objectClass=posixGroup)",
+		Membership:         MembershipDN,
+		Password:           PasswordAttr,
+		Lock:               LockNsAccountLock,
+	}
+	// ProfileFreeIPA is the FreeIPA accounts tree on 389-DS
+	ProfileFreeIPA = &Profile{
+		Name:               "freeipa",
+		PeopleRdn:          "cn=users,cn=accounts",
+		GroupRdn:           "cn=groups,cn=accounts",
+		UserRdnAttr:        "uid",
+		LoginAttr:          "uid",
+		HomeAttr:           "homeDirectory",
+		UserObjectClasses:  []string{"top", "person", "organizationalPerson", "inetOrgPerson", "inetUser", "posixAccount"},
+		GroupObjectClasses: []string{"top", "groupOfNames", "nestedGroup", "posixGroup"},
+		UserFilter:         "(objectClass=posixAccount)",
+		GroupFilter:        "(objectClass=posixGroup)",
+		Membership:         MembershipDN,
+		Password:           PasswordAttr,
+		Lock:               LockNsAccountLock,
+	}
+	// ProfileActiveDirectory is Active Directory with its RFC2307 attributes
+	ProfileActiveDirectory = &Profile{
+		Name:               "ad",
+		PeopleRdn:          "cn=Users",
+		GroupRdn:           "cn=Users",
+		UserRdnAttr:        "cn",
+		LoginAttr:          "sAMAccountName",
+		GroupLoginAttr:     "sAMAccountName",
+		HomeAttr:           "unixHomeDirectory",
+		UserObjectClasses:  []string{"top", "person", "organizationalPerson", "user"},
+		GroupObjectClasses: []string{"top", "group"},
+		UserFilter:         "(&(objectClass=user)(objectCategory=person))",
+		GroupFilter:        "(objectClass=group)",
+		// normal account
+		UserAttrs: map[string][]string{"userAccountControl": {"512"}},
+		// global security group
+		GroupAttrs: map[string][]string{"groupType": {"-2147483646"}},
+		Membership: MembershipDN,
+		Password:   PasswordUnicodePwd,
+		Lock:       LockUserAccountControl,
+	}
+)
+
+// Profiles ... built-in profiles by name
+var Profiles = map[string]*Profile{
+	ProfileRFC2307.Name:         ProfileRFC2307,
+	ProfileOpenLDAPSamba.Name:   ProfileOpenLDAPSamba,
+	Profile389DS.Name:           Profile389DS,
+	ProfileFreeIPA.Name:         ProfileFreeIPA,
+	ProfileActiveDirectory.Name: ProfileActiveDirectory,
+}
+
+// ProfileNames ... sorted names of the built-in profiles
+func ProfileNames() []string {
+	names := []string{}
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupProfile ... built-in profile by name
+func LookupProfile(name string) (p *Profile, err error) {
+	p, ok := Profiles[name]
+	if !ok {
+		err = fmt.Errorf("unknown profile %s, one of %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	return
+}
+
+// profile ... schema profile of the client, OpenLDAP+Samba if unset
+func (lc *LDAPClient) profile() *Profile {
+	if lc.Profile == nil {
+		return ProfileOpenLDAPSamba
+	}
+	return lc.Profile
+}
+
+// userFilter ... filter matching the user with login name username. The
+// profile's UserFilter keeps out groups sharing the login attribute and
+// container, like those of Active Directory
+func (lc *LDAPClient) userFilter(username string) string {
+	p := lc.profile()
+	return fmt.Sprintf("(&%s(%s=%s))", p.UserFilter, p.LoginAttr, ldap.EscapeFilter(username))
+}
+
+// userAttributes ... attributes of a new user, without password and samba attributes
+func (p *Profile) userAttributes(spec UserSpec) map[string][]string {
+	username := spec.Name
+	gidStr := spec.GIDNumber
+	if gidStr == "" {
+		gidStr = "0"
+	}
+	userAttr := make(map[string][]string)
+	userAttr["objectClass"] = p.UserObjectClasses
+	userAttr[p.UserRdnAttr] = []string{username}
+	userAttr[p.LoginAttr] = []string{username}
+	userAttr["cn"] = []string{username}
+	userAttr["givenName"] = []string{username}
+	userAttr["sn"] = []string{username}
+	userAttr["displayName"] = []string{username}
+	userAttr["uidNumber"] = []string{spec.UIDNumber}
+	userAttr["gidNumber"] = []string{gidStr}
+	userAttr[p.HomeAttr] = []string{"/home/" + username}
+	userAttr["loginShell"] = []string{"/bin/bash"}
+	for k, v := range p.UserAttrs {
+		userAttr[k] = v
+	}
+	return userAttr
+}
+
+// groupAttributes ... attributes of a new group, without samba attributes
+func (p *Profile) groupAttributes(groupname string, gidStr string) map[string][]string {
+	groupAttr := make(map[string][]string)
+	groupAttr["objectClass"] = p.GroupObjectClasses
+	groupAttr["cn"] = []string{groupname}
+	groupAttr["gidNumber"] = []string{gidStr}
+	if p.GroupLoginAttr != "" {
+		groupAttr[p.GroupLoginAttr] = []string{groupname}
+	}
+	for k, v := range p.GroupAttrs {
+		groupAttr[k] = v
+	}
+	return groupAttr
+}
+
+// createUnicodePwd ... Active Directory unicodePwd value, the quoted password in UTF-16LE
+func createUnicodePwd(password string) (string, error) {
+	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	return utf16.NewEncoder().String("\"" + password + "\"")
+}
+
+// passwordAttributes ... attributes setting password according to the profile
+func (lc *LDAPClient) passwordAttributes(password string) (attrs map[string][]string, err error) {
+	attrs = make(map[string][]string)
+	switch lc.profile().Password {
+	case PasswordUnicodePwd:
+		pwd, perr := createUnicodePwd(password)
+		if perr != nil {
+			err = perr
+			return
+		}
+		attrs["unicodePwd"] = []string{pwd}
+	default:
+		attrs["userPassword"] = []string{password}
+	}
+	return
+}
@@ -0,0 +1,27 @@
+package utils
+
+import "testing"
+
+func Test_profileUserAttributes(t *testing.T) {
+	attrs := ProfileActiveDirectory.userAttributes(UserSpec{Name: "alice", UIDNumber: "50000"})
+	if attrs["sAMAccountName"][0] != "alice" || attrs["unixHomeDirectory"][0] != "/home/alice" {
+		t.Fatalf("unexpected attributes: %v", attrs)
+	}
+	lc := &LDAPClient{BaseDn: "dc=test,dc=com", Profile: ProfileActiveDirectory}
+	if lc.UserDn("alice") != "cn=alice,cn=Users,dc=test,dc=com" {
+		t.Fatalf("unexpected dn: %s", lc.UserDn("alice"))
+	}
+	if lc.userFilter("alice") != "(&(&(objectClass=user)(objectCategory=person))(sAMAccountName=alice))" {
+		t.Fatalf("unexpected filter: %s", lc.userFilter("alice"))
+	}
+}
+
+func Test_createUnicodePwd(t *testing.T) {
+	pwd, err := createUnicodePwd("ab")
+	if err != nil {
+		t.Fatalf("error encoding password: %v", err)
+	}
+	if pwd != "\"\x00a\x00b\x00\"\x00" {
+		t.Fatalf("unexpected unicodePwd: %q", pwd)
+	}
+}
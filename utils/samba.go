@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// sambaObjectClasses ... object classes only present with the samba schema
+var sambaObjectClasses = map[string]bool{
+	"sambasamaccount":   true,
+	"sambagroupmapping": true,
+}
+
+// withoutSambaClasses ... object classes minus the samba ones
+func withoutSambaClasses(classes []string) []string {
+	out := []string{}
+	for _, class := range classes {
+		if !sambaObjectClasses[strings.ToLower(class)] {
+			out = append(out, class)
+		}
+	}
+	return out
+}
+
+// ReadEntry ... attributes of the single entry dn
+func (lc *LDAPClient) ReadEntry(dn string, attrs []string) (entry LdapResult, err error) {
+	conn, err := lc.reader()
+	if err != nil {
+		return
+	}
+	return readEntry(conn, dn, attrs)
+}
+
+func readEntry(conn *ldap.Conn, dn string, attrs []string) (entry LdapResult, err error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		attrs,
+		nil,
+	)
+	sr, err := conn.Search(searchRequest)
+	if err != nil {
+		return
+	}
+	entry.DN = dn
+	entry.Attributes = make(map[string][]string)
+	for _, e := range sr.Entries {
+		for _, attr := range e.Attributes {
+			entry.Attributes[attr.Name] = attr.Values
+		}
+	}
+	return
+}
+
+// HasSambaSchema ... whether the server subschema defines sambaSamAccount
+func (lc *LDAPClient) HasSambaSchema() (found bool, err error) {
+	if lc.sambaSchema != nil {
+		return *lc.sambaSchema, nil
+	}
+	rootDSE, err := lc.ReadEntry("", []string{"subschemaSubentry"})
+	if err != nil {
+		return
+	}
+	subschema := rootDSE.Values("subschemaSubentry")
+	if len(subschema) == 0 {
+		subschema = []string{"cn=Subschema"}
+	}
+	schema, err := lc.ReadEntry(subschema[0], []string{"objectClasses"})
+	if err != nil {
+		return
+	}
+	for _, class := range schema.Values("objectClasses") {
+		if strings.Contains(strings.ToLower(class), "name 'sambasamaccount'") {
+			found = true
+			break
+		}
+	}
+	lc.sambaSchema = &found
+	return
+}
+
+// SambaEnabled ... whether samba attributes are written: the profile uses them,
+// they are not switched off and the server has the samba schema
+func (lc *LDAPClient) SambaEnabled() bool {
+	if lc.NoSamba || !lc.profile().Samba {
+		return false
+	}
+	found, err := lc.HasSambaSchema()
+	if err != nil {
+		// cannot tell, keep the profile's behaviour
+		return true
+	}
+	return found
+}
+
+// RID allocation methods
+const (
+	// RidAlgorithmic derives the rid from uidNumber and gidNumber
+	RidAlgorithmic = "algorithmic"
+	// RidNextRid allocates rids from sambaNextRid of the domain
+	RidNextRid = "nextrid"
+)
+
+// defaultRidBase ... sambaAlgorithmicRidBase if the domain does not set it
+const defaultRidBase = 1000
+
+// SambaDomain ... a sambaDomain entry
+type SambaDomain struct {
+	DN                 string `json:"dn"`
+	Name               string `json:"name"`
+	SID                string `json:"sid"`
+	AlgorithmicRidBase int    `json:"algorithmicRidBase"`
+	NextRid            int    `json:"nextRid,omitempty"`
+}
+
+// UserRid ... algorithmic rid of uidNumber
+func (d SambaDomain) UserRid(uid int) int {
+	return uid*2 + d.AlgorithmicRidBase
+}
+
+// GroupRid ... algorithmic rid of gidNumber, odd so it never collides with a user
+func (d SambaDomain) GroupRid(gid int) int {
+	return gid*2 + d.AlgorithmicRidBase + 1
+}
+
+// Sid ... sid of rid in the domain
+func (d SambaDomain) Sid(rid int) string {
+	return fmt.Sprintf("%s-%d", d.SID, rid)
+}
+
+func sambaDomainOf(entry LdapResult) (d SambaDomain) {
+	d.DN = entry.DN
+	d.AlgorithmicRidBase = defaultRidBase
+	if v := entry.Values("sambaDomainName"); len(v) > 0 {
+		d.Name = v[0]
+	}
+	if v := entry.Values("sambaSID"); len(v) > 0 {
+		d.SID = v[0]
+	}
+	if v := entry.Values("sambaAlgorithmicRidBase"); len(v) > 0 {
+		if base, err := strconv.Atoi(v[0]); err == nil {
+			d.AlgorithmicRidBase = base
+		}
+	}
+	if v := entry.Values("sambaNextRid"); len(v) > 0 {
+		d.NextRid, _ = strconv.Atoi(v[0])
+	}
+	return
+}
+
+// SambaDomains ... all sambaDomain entries below the basedn
+func (lc *LDAPClient) SambaDomains() (domains []SambaDomain, err error) {
+	attrs := []string{"sambaDomainName", "sambaSID", "sambaAlgorithmicRidBase", "sambaNextRid"}
+	data, err := lc.SearchEntries("(objectClass=sambaDomain)", attrs, lc.BaseDn)
+	if err != nil {
+		return
+	}
+	for _, entry := range data {
+		domains = append(domains, sambaDomainOf(entry))
+	}
+	return
+}
+
+// LookupSambaDomain ... the configured sambaDomain; without a name the only
+// domain, or the one named SAMBA if there are several
+func (lc *LDAPClient) LookupSambaDomain() (domain SambaDomain, err error) {
+	domains, err := lc.SambaDomains()
+	if err != nil {
+		return
+	}
+	names := []string{}
+	for _, d := range domains {
+		if lc.SambaDomain != "" && strings.EqualFold(d.Name, lc.SambaDomain) {
+			return d, nil
+		}
+		names = append(names, d.Name)
+	}
+	switch {
+	case lc.SambaDomain != "":
+		err = fmt.Errorf("samba domain %s not found", lc.SambaDomain)
+	case len(domains) == 0:
+		err = errors.New("no sambaDomain entry found")
+	case len(domains) == 1:
+		domain = domains[0]
+	default:
+		for _, d := range domains {
+			if d.Name == sambadomain {
+				return d, nil
+			}
+		}
+		err = fmt.Errorf("several samba domains (%s), choose one with --samba-domain", strings.Join(names, ", "))
+	}
+	return
+}
+
+// allocateRid ... take the next rid from sambaNextRid of the domain, read
+// again from the primary as a replica may not have the last allocation yet.
+// The old value is deleted in the same modify request, so a concurrent
+// allocation fails
+func (lc *LDAPClient) allocateRid(domain SambaDomain) (rid int, err error) {
+	defer lc.readPrimary()()
+	entry, err := lc.ReadEntry(domain.DN, []string{"sambaNextRid"})
+	if err != nil {
+		return
+	}
+	if rid = sambaDomainOf(entry).NextRid; rid == 0 {
+		err = fmt.Errorf("samba domain %s has no sambaNextRid", domain.Name)
+		return
+	}
+	modify := ldap.NewModifyRequest(domain.DN)
+	modify.Delete("sambaNextRid", []string{strconv.Itoa(rid)})
+	modify.Add("sambaNextRid", []string{strconv.Itoa(rid + 1)})
+	err = lc.Modify(modify)
+	return
+}
+
+// UserSid ... sambaSID for a new user with uidNumber uid
+func (lc *LDAPClient) UserSid(uid int) (sid string, err error) {
+	domain, err := lc.LookupSambaDomain()
+	if err != nil {
+		return
+	}
+	rid := domain.UserRid(uid)
+	if lc.RidAllocation == RidNextRid {
+		if rid, err = lc.allocateRid(domain); err != nil {
+			return
+		}
+	}
+	return domain.Sid(rid), nil
+}
+
+// GroupSid ... sambaSID for a new group with gidNumber gid
+func (lc *LDAPClient) GroupSid(gid int) (sid string, err error) {
+	domain, err := lc.LookupSambaDomain()
+	if err != nil {
+		return
+	}
+	rid := domain.GroupRid(gid)
+	if lc.RidAllocation == RidNextRid {
+		if rid, err = lc.allocateRid(domain); err != nil {
+			return
+		}
+	}
+	return domain.Sid(rid), nil
+}
+
+// domainUsersRid ... well-known rid of Domain Users, the fallback primary group
+const domainUsersRid = 513
+
+// SambaTemplates ... values of the samba profile attributes of new users,
+// %u is replaced by the username, e.g. \\fileserver\%u
+type SambaTemplates struct {
+	HomePath    string
+	ProfilePath string
+	LogonScript string
+	HomeDrive   string
+}
+
+// ExpandSambaTemplate ... template with %u replaced by username
+func ExpandSambaTemplate(template string, username string) string {
+	return strings.Replace(template, "%u", username, -1)
+}
+
+// attributes ... the non-empty templates expanded for username
+func (t SambaTemplates) attributes(username string) map[string][]string {
+	attrs := make(map[string][]string)
+	for attr, template := range map[string]string{
+		"sambaHomePath":    t.HomePath,
+		"sambaProfilePath": t.ProfilePath,
+		"sambaLogonScript": t.LogonScript,
+		"sambaHomeDrive":   t.HomeDrive,
+	} {
+		if template != "" {
+			attrs[attr] = []string{ExpandSambaTemplate(template, username)}
+		}
+	}
+	return attrs
+}
+
+// PrimaryGroupSid ... sambaSID of the group mapping of gidNumber, Domain Users
+// with a warning if no mapped group has gidNumber, as for the default gid 0
+func (lc *LDAPClient) PrimaryGroupSid(gidNumber string) (sid string, err error) {
+	filter := fmt.Sprintf("(&(objectClass=sambaGroupMapping)(gidNumber=%s))", ldap.EscapeFilter(gidNumber))
+	data, err := lc.SearchEntries(filter, []string{"sambaSID"}, lc.BaseDn)
+	if err != nil {
+		return
+	}
+	for _, entry := range data {
+		if v := entry.Values("sambaSID"); len(v) > 0 {
+			return v[0], nil
+		}
+	}
+	domain, err := lc.LookupSambaDomain()
+	if err != nil {
+		return
+	}
+	if groupname, gerr := lc.GidGroup(gidNumber); gerr != nil {
+		fmt.Fprintf(os.Stderr, "WARN: %s, using Domain Users as primary group\n", gerr.Error())
+	} else {
+		fmt.Fprintf(os.Stderr, "WARN: group %s has no samba mapping, using Domain Users as primary group\n", groupname)
+	}
+	return domain.Sid(domainUsersRid), nil
+}
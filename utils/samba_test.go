@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+func Test_sambaDomainRids(t *testing.T) {
+	d := sambaDomainOf(LdapResult{
+		DN: "sambaDomainName=SAMBA,dc=test,dc=com",
+		Attributes: map[string][]string{
+			"sambaDomainName": {"SAMBA"},
+			"sambaSID":        {"S-1-5-21-1-2-3"},
+		},
+	})
+	if d.AlgorithmicRidBase != defaultRidBase {
+		t.Fatalf("unexpected rid base: %d", d.AlgorithmicRidBase)
+	}
+	if d.Sid(d.UserRid(500)) != "S-1-5-21-1-2-3-2000" || d.Sid(d.GroupRid(500)) != "S-1-5-21-1-2-3-2001" {
+		t.Fatalf("unexpected sids: %s %s", d.Sid(d.UserRid(500)), d.Sid(d.GroupRid(500)))
+	}
+}
+
+// sambaFixture ... posixFixture with the samba domain SAMBA
+func sambaFixture() []LdapResult {
+	return append(posixFixture(), fixture("sambaDomainName=SAMBA,dc=test,dc=com", "objectClass", "sambaDomain",
+		"sambaDomainName", "SAMBA", "sambaSID", "S-1-5-21-1-2-3"))
+}
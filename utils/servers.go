@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Order in which the servers of a url list are tried
+const (
+	BalanceOrder      = "order"
+	BalanceRoundRobin = "round-robin"
+)
+
+// defaultLdapiSocket ... socket of ldapi:/// as built into OpenLDAP
+const defaultLdapiSocket = "/var/run/ldapi"
+
+// roundRobin ... start of the next round robin connect
+var roundRobin uint32
+
+// serverURL ... where and how to reach one server
+type serverURL struct {
+	URL     string
+	Network string
+	Address string
+	TLS     bool
+}
+
+// withPort ... hostport with port appended if it has none
+func withPort(hostport string, port string) string {
+	if _, _, err := net.SplitHostPort(hostport); err == nil {
+		return hostport
+	}
+	return net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+}
+
+// parseServerURLs ... servers of a comma or space separated list of
+// ldap://host[:port], ldaps://host[:port], ldapi://socket or plain host:port
+// urls, plain ones using TLS if useTLS
+func parseServerURLs(list string, useTLS bool) (servers []serverURL, err error) {
+	for _, s := range strings.Fields(strings.Replace(list, ",", " ", -1)) {
+		scheme, rest := "", s
+		if i := strings.Index(s, "://"); i >= 0 {
+			scheme, rest = strings.ToLower(s[:i]), s[i+3:]
+		}
+		if scheme != "ldapi" {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				rest = rest[:i]
+			}
+		}
+		server := serverURL{URL: s, Network: "tcp"}
+		switch scheme {
+		case "":
+			server.TLS = useTLS
+			if useTLS {
+				server.Address = withPort(rest, "636")
+			} else {
+				server.Address = withPort(rest, "389")
+			}
+		case "ldap":
+			server.Address = withPort(rest, "389")
+		case "ldaps":
+			server.TLS = true
+			server.Address = withPort(rest, "636")
+		case "ldapi":
+			server.Network = "unix"
+			if server.Address, err = url.PathUnescape(strings.TrimSuffix(rest, "/")); err != nil {
+				return nil, fmt.Errorf("invalid ldapi url %s", s)
+			}
+			if server.Address == "" {
+				server.Address = defaultLdapiSocket
+			}
+		default:
+			return nil, fmt.Errorf("unsupported url %s, ldap://, ldaps:// or ldapi://", s)
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		err = errors.New("no ldap url")
+	}
+	return
+}
+
+// connectServer ... connection to server, bound as lc.BindDn
+func (lc *LDAPClient) connectServer(ctx context.Context, server serverURL) (conn *ldap.Conn, err error) {
+	if conn, err = lc.dial(ctx, server); err != nil {
+		return nil, err
+	}
+	lc.watch(ctx, conn)
+	if !server.TLS && server.Network == "tcp" && lc.StartTLS {
+		if err = conn.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+			conn.Close()
+			return nil, contextError(ctx, err)
+		}
+	}
+	if err = conn.Bind(lc.BindDn, lc.BindPass); err != nil {
+		conn.Close()
+		return nil, contextError(ctx, err)
+	}
+	return
+}
+
+// connectAny ... connection to the first server of list that can be reached,
+// starting with the next one in turn for BalanceRoundRobin. Only network
+// errors fail over, a refused bind is returned as is
+func (lc *LDAPClient) connectAny(ctx context.Context, list string, balance string) (conn *ldap.Conn, err error) {
+	servers, err := parseServerURLs(list, lc.TLS)
+	if err != nil {
+		return
+	}
+	start := 0
+	if balance == BalanceRoundRobin {
+		start = int((atomic.AddUint32(&roundRobin, 1) - 1) % uint32(len(servers)))
+	}
+	var failed []string
+	for i := range servers {
+		server := servers[(start+i)%len(servers)]
+		conn, err = lc.connectServer(ctx, server)
+		if err == nil || !IsNetworkError(err) || len(servers) == 1 {
+			return
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s", server.URL, err.Error()))
+	}
+	return nil, ldap.NewError(ldap.ErrorNetwork, errors.New("no ldap server reachable: "+strings.Join(failed, "; ")))
+}
+
+// writer ... connection for write requests, to the primary of WriteAddr if
+// set, connected on the first write
+func (lc *LDAPClient) writer() (conn *ldap.Conn, err error) {
+	if err = lc.Context().Err(); err != nil {
+		return
+	}
+	if lc.WriteAddr == "" {
+		return lc.Conn, nil
+	}
+	if lc.writeConn == nil {
+		if lc.writeConn, err = lc.connectAny(lc.Context(), lc.WriteAddr, BalanceOrder); err != nil {
+			return
+		}
+	}
+	return lc.writeConn, nil
+}
+
+// readPrimary ... send searches to the writer until the returned func is
+// called, for reads that decide on writes and must not see a lagging replica:
+//
+//	defer lc.readPrimary()()
+func (lc *LDAPClient) readPrimary() (done func()) {
+	lc.primaryReads++
+	return func() { lc.primaryReads-- }
+}
+
+// reader ... connection for searches, the writer inside a Transaction or
+// readPrimary and lc.Conn otherwise
+func (lc *LDAPClient) reader() (conn *ldap.Conn, err error) {
+	if lc.journal != nil || lc.primaryReads > 0 {
+		return lc.writer()
+	}
+	return lc.Conn, nil
+}
+
+// search ... send searchRequest over the reader
+func (lc *LDAPClient) search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	conn, err := lc.reader()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Search(searchRequest)
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_parseServerURLs(t *testing.T) {
+	servers, err := parseServerURLs("ldap://a.example.com, ldaps://b.example.com:1636/ ldapi://%2fvar%2frun%2fslapd%2fldapi 10.0.0.1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []serverURL{
+		{Network: "tcp", Address: "a.example.com:389"},
+		{Network: "tcp", Address: "b.example.com:1636", TLS: true},
+		{Network: "unix", Address: "/var/run/slapd/ldapi"},
+		{Network: "tcp", Address: "10.0.0.1:389"},
+	}
+	if len(servers) != len(expected) {
+		t.Fatalf("unexpected servers: %+v", servers)
+	}
+	for i, server := range servers {
+		server.URL = ""
+		if server != expected[i] {
+			t.Errorf("server %d: expected %+v, got %+v", i, expected[i], server)
+		}
+	}
+
+	if servers, _ = parseServerURLs("ldapi:///", false); servers[0].Address != defaultLdapiSocket {
+		t.Errorf("unexpected default socket %s", servers[0].Address)
+	}
+	if servers, _ = parseServerURLs("[::1]", true); servers[0].Address != "[::1]:636" || !servers[0].TLS {
+		t.Errorf("unexpected tls host: %+v", servers[0])
+	}
+	if _, err = parseServerURLs("http://a.example.com", false); err == nil {
+		t.Error("expected error for http url")
+	}
+	if _, err = parseServerURLs(" , ", false); err == nil {
+		t.Error("expected error for empty list")
+	}
+}
+
+func Test_connectFailover(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, "ldap://"+l.Addr().String())
+		l.Close()
+	}
+	lc := &LDAPClient{Addr: strings.Join(addrs, ",")}
+	err := lc.Connect()
+	if !IsNetworkError(err) || !strings.Contains(err.Error(), addrs[0]) || !strings.Contains(err.Error(), addrs[1]) {
+		t.Fatalf("expected both servers to be tried, got %v", err)
+	}
+}
+
+func Test_readsBeforeWritesOnPrimary(t *testing.T) {
+	domain := fixture("sambaDomainName=SAMBA,dc=test,dc=com", "objectClass", "sambaDomain",
+		"sambaDomainName", "SAMBA", "sambaSID", "S-1-5-21-1-2-3", "sambaNextRid", "5000")
+	replica := newFakeServer(t, append(posixFixture(), domain)...)
+	defer replica.Close()
+	primary := newFakeServer(t, append(posixFixture(), domain,
+		fixture("uid=dave,ou=People,dc=test,dc=com", "objectClass", "posixAccount", "uid", "dave", "uidNumber", "1004"))...)
+	defer primary.Close()
+
+	lc := replica.client("dc=test,dc=com", ProfileOpenLDAPSamba)
+	lc.WriteAddr = primary.Addr()
+	lc.RidAllocation = RidNextRid
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	// the replica keeps sambaNextRid 5000, each rid is read from the primary
+	for _, want := range []string{"S-1-5-21-1-2-3-5000", "S-1-5-21-1-2-3-5001"} {
+		if sid, err := lc.UserSid(1005); err != nil || sid != want {
+			t.Fatalf("expected %s, got %s %v", want, sid, err)
+		}
+	}
+	if err := lc.AddUser("dave", "1004", "secret"); err == nil || !strings.Contains(err.Error(), "existed") {
+		t.Fatalf("user only on the primary not seen inside the transaction: %v", err)
+	}
+	if writes := replica.Writes(); len(writes) > 0 {
+		t.Fatalf("writes sent to the replica: %v", writes)
+	}
+	if entry, _ := primary.entry(domain.DN); entry.Values("sambaNextRid")[0] != "5002" {
+		t.Fatalf("unexpected sambaNextRid on the primary: %v", entry.Values("sambaNextRid"))
+	}
+}
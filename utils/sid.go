@@ -0,0 +1,310 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Kinds of accounts a sid can name
+const (
+	SidUser  = "user"
+	SidGroup = "group"
+)
+
+// wellKnownRids ... names of the well-known domain rids
+var wellKnownRids = map[int]string{
+	500: "Administrator",
+	501: "Guest",
+	512: "Domain Admins",
+	513: "Domain Users",
+	514: "Domain Guests",
+	515: "Domain Computers",
+	516: "Domain Controllers",
+}
+
+// wellKnownSids ... names of the well-known sids outside any domain
+var wellKnownSids = map[string]string{
+	"S-1-1-0":      "Everyone",
+	"S-1-5-11":     "Authenticated Users",
+	"S-1-5-18":     "Local System",
+	"S-1-5-32-544": "BUILTIN\\Administrators",
+	"S-1-5-32-545": "BUILTIN\\Users",
+	"S-1-5-32-546": "BUILTIN\\Guests",
+	"S-1-5-32-547": "BUILTIN\\Power Users",
+	"S-1-5-32-548": "BUILTIN\\Account Operators",
+	"S-1-5-32-549": "BUILTIN\\Server Operators",
+	"S-1-5-32-550": "BUILTIN\\Print Operators",
+	"S-1-5-32-551": "BUILTIN\\Backup Operators",
+}
+
+// SidInfo ... what a sid names, from the directory and the algorithmic mapping
+type SidInfo struct {
+	SID    string `json:"sid"`
+	Domain string `json:"domain,omitempty"`
+	RID    int    `json:"rid,omitempty"`
+	// Kind, Name and DN come from the entry holding the sambaSID
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name,omitempty"`
+	DN   string `json:"dn,omitempty"`
+	// UIDNumber or GIDNumber of the entry, or computed from the rid
+	UIDNumber string `json:"uidNumber,omitempty"`
+	GIDNumber string `json:"gidNumber,omitempty"`
+	// Computed is set when the sid was derived with the algorithmic mapping
+	// instead of read from an entry
+	Computed  bool   `json:"computed,omitempty"`
+	WellKnown string `json:"wellKnown,omitempty"`
+}
+
+// SplitSid ... domain sid and rid of sid
+func SplitSid(sid string) (domainSid string, rid int, err error) {
+	parts := strings.Split(sid, "-")
+	if len(parts) < 4 || !strings.EqualFold(parts[0], "S") {
+		err = fmt.Errorf("invalid sid %s", sid)
+		return
+	}
+	for _, part := range parts[1:] {
+		if _, perr := strconv.ParseUint(part, 10, 64); perr != nil {
+			err = fmt.Errorf("invalid sid %s", sid)
+			return
+		}
+	}
+	rid, err = strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		err = fmt.Errorf("invalid sid %s", sid)
+		return
+	}
+	domainSid = strings.Join(parts[:len(parts)-1], "-")
+	return
+}
+
+// IDOfRid ... uidNumber (even) or gidNumber (odd) an algorithmic rid was derived from
+func (d SambaDomain) IDOfRid(rid int) (kind string, id int, ok bool) {
+	offset := rid - d.AlgorithmicRidBase
+	if offset < 0 {
+		return
+	}
+	if offset%2 == 0 {
+		return SidUser, offset / 2, true
+	}
+	return SidGroup, (offset - 1) / 2, true
+}
+
+// sidDomain ... samba domain sid belongs to, if it is one of ours
+func (lc *LDAPClient) sidDomain(domainSid string) (domain SambaDomain, ok bool, err error) {
+	domains, err := lc.SambaDomains()
+	if err != nil {
+		return
+	}
+	for _, d := range domains {
+		if strings.EqualFold(d.SID, domainSid) {
+			return d, true, nil
+		}
+	}
+	return
+}
+
+// sidInfoOf ... fill kind, name and ids of info from entry
+func (lc *LDAPClient) sidInfoOf(info *SidInfo, entry LdapResult) {
+	info.DN = entry.DN
+	info.Computed = false
+	if hasObjectClass(entry, "sambaGroupMapping") || hasObjectClass(entry, "posixGroup") || hasObjectClass(entry, "group") {
+		info.Kind = SidGroup
+		if v := entry.Values("cn"); len(v) > 0 {
+			info.Name = v[0]
+		}
+		info.UIDNumber = ""
+		if v := entry.Values("gidNumber"); len(v) > 0 {
+			info.GIDNumber = v[0]
+		}
+		return
+	}
+	info.Kind = SidUser
+	if v := entry.Values(lc.profile().LoginAttr); len(v) > 0 {
+		info.Name = v[0]
+	}
+	info.GIDNumber = ""
+	if v := entry.Values("uidNumber"); len(v) > 0 {
+		info.UIDNumber = v[0]
+	}
+}
+
+// LookupSid ... user or group with sambaSID sid. Without such an entry the
+// uidNumber or gidNumber is computed from the rid of a known domain
+func (lc *LDAPClient) LookupSid(sid string) (info SidInfo, err error) {
+	domainSid, rid, err := SplitSid(sid)
+	if err != nil {
+		return
+	}
+	info = SidInfo{SID: sid, RID: rid, WellKnown: wellKnownSids[strings.ToUpper(sid)]}
+	domain, ok, err := lc.sidDomain(domainSid)
+	if err != nil {
+		return
+	}
+	if ok {
+		info.Domain = domain.Name
+		if info.WellKnown == "" {
+			info.WellKnown = wellKnownRids[rid]
+		}
+		if kind, id, idok := domain.IDOfRid(rid); idok {
+			info.Kind = kind
+			info.Computed = true
+			if kind == SidUser {
+				info.UIDNumber = strconv.Itoa(id)
+			} else {
+				info.GIDNumber = strconv.Itoa(id)
+			}
+		}
+	}
+	filter := fmt.Sprintf("(sambaSID=%s)", ldap.EscapeFilter(sid))
+	attrs := []string{"objectClass", "cn", "uidNumber", "gidNumber", lc.profile().LoginAttr}
+	data, err := lc.SearchEntries(filter, attrs, lc.BaseDn)
+	if err != nil {
+		return
+	}
+	if len(data) > 0 {
+		lc.sidInfoOf(&info, data[0])
+	}
+	if info.Kind == "" && info.WellKnown == "" {
+		err = fmt.Errorf("sid %s is not known", sid)
+	}
+	return
+}
+
+// SidOf ... sids of the user and/or group named name, computed from
+// uidNumber or gidNumber if the entry has no sambaSID
+func (lc *LDAPClient) SidOf(name string) (infos []SidInfo, err error) {
+	attrs := []string{"objectClass", "cn", "uidNumber", "gidNumber", "sambaSID", lc.profile().LoginAttr}
+	users, err := lc.SearchEntries(lc.userFilter(name), attrs, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	groupFilter := fmt.Sprintf("(&%s(cn=%s))", lc.profile().GroupFilter, ldap.EscapeFilter(name))
+	groups, err := lc.SearchEntries(groupFilter, attrs, lc.GroupDn())
+	if err != nil {
+		return
+	}
+	for _, entry := range append(users, groups...) {
+		info := SidInfo{}
+		lc.sidInfoOf(&info, entry)
+		if v := entry.Values("sambaSID"); len(v) > 0 {
+			info.SID = v[0]
+		} else {
+			number := info.UIDNumber
+			if info.Kind == SidGroup {
+				number = info.GIDNumber
+			}
+			computed, cerr := lc.ComputeSid(info.Kind, number)
+			if cerr != nil {
+				err = cerr
+				return
+			}
+			info.SID = computed.SID
+			info.Computed = true
+		}
+		if domainSid, rid, serr := SplitSid(info.SID); serr == nil {
+			info.RID = rid
+			if domain, ok, derr := lc.sidDomain(domainSid); derr == nil && ok {
+				info.Domain = domain.Name
+			}
+		}
+		infos = append(infos, info)
+	}
+	if len(infos) == 0 {
+		err = fmt.Errorf("no user or group %s", name)
+	}
+	return
+}
+
+// ComputeSid ... algorithmic sid of a uidNumber (kind user) or gidNumber (kind group),
+// whether or not an entry with that number exists
+func (lc *LDAPClient) ComputeSid(kind string, number string) (info SidInfo, err error) {
+	id, err := strconv.Atoi(number)
+	if err != nil {
+		return
+	}
+	domain, err := lc.LookupSambaDomain()
+	if err != nil {
+		return
+	}
+	info = SidInfo{Kind: kind, Domain: domain.Name, Computed: true}
+	switch kind {
+	case SidUser:
+		info.RID = domain.UserRid(id)
+		info.UIDNumber = number
+	case SidGroup:
+		info.RID = domain.GroupRid(id)
+		info.GIDNumber = number
+	default:
+		err = errors.New("kind must be user or group")
+		return
+	}
+	info.SID = domain.Sid(info.RID)
+	return
+}
+
+// sidJSON ... indented json of v
+func sidJSON(v interface{}) (data string, err error) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	data = string(bytes)
+	return
+}
+
+// LookupSid ... user or group of sid
+func LookupSid(lc *LDAPClient, sid string) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	info, err := lc.LookupSid(sid)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return sidJSON(info)
+}
+
+// SidOf ... sids of user or group name
+func SidOf(lc *LDAPClient, name string) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	infos, err := lc.SidOf(name)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return sidJSON(infos)
+}
+
+// ComputeSid ... algorithmic sid of a uidNumber or gidNumber
+func ComputeSid(lc *LDAPClient, kind string, number string) (data string, err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	info, err := lc.ComputeSid(kind, number)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	return sidJSON(info)
+}
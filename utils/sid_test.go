@@ -0,0 +1,28 @@
+package utils
+
+import "testing"
+
+func Test_splitSid(t *testing.T) {
+	domainSid, rid, err := SplitSid("S-1-5-21-1-2-3-2001")
+	if err != nil || domainSid != "S-1-5-21-1-2-3" || rid != 2001 {
+		t.Fatalf("unexpected split: %s %d %v", domainSid, rid, err)
+	}
+	for _, sid := range []string{"", "S-1-5", "X-1-5-21", "S-1-5-21-a"} {
+		if _, _, err := SplitSid(sid); err == nil {
+			t.Fatalf("expected error for %q", sid)
+		}
+	}
+}
+
+func Test_idOfRid(t *testing.T) {
+	d := SambaDomain{SID: "S-1-5-21-1-2-3", AlgorithmicRidBase: defaultRidBase}
+	if kind, id, ok := d.IDOfRid(d.UserRid(500)); !ok || kind != SidUser || id != 500 {
+		t.Fatalf("unexpected user rid: %s %d %v", kind, id, ok)
+	}
+	if kind, id, ok := d.IDOfRid(d.GroupRid(500)); !ok || kind != SidGroup || id != 500 {
+		t.Fatalf("unexpected group rid: %s %d %v", kind, id, ok)
+	}
+	if _, _, ok := d.IDOfRid(513); ok {
+		t.Fatal("rid below the base is not algorithmic")
+	}
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Status of an entry after a create or membership operation
+const (
+	StatusCreated   = "created"
+	StatusChanged   = "changed"
+	StatusUnchanged = "unchanged"
+)
+
+// OperationResult ... outcome of a create operation
+type OperationResult struct {
+	DN     string `json:"dn"`
+	Status string `json:"status"`
+}
+
+// JSON ... result as indented json
+func (r OperationResult) JSON() (data string, err error) {
+	resultbytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return
+	}
+	data = string(resultbytes)
+	return
+}
+
+// existingNumber ... whether an entry matching filter exists below basedn and its value of attr
+func (lc *LDAPClient) existingNumber(basedn string, filter string, attr string) (exists bool, value string, err error) {
+	data, err := lc.SearchEntries(filter, []string{attr}, basedn)
+	if err != nil || len(data) == 0 {
+		return
+	}
+	exists = true
+	if v := data[0].Attributes[attr]; len(v) > 0 {
+		value = v[0]
+	}
+	return
+}
+
+// CreateUser ... AddUser, in idempotent mode an existing user with the same uidNumber is unchanged
+func (lc *LDAPClient) CreateUser(spec UserSpec) (result OperationResult, err error) {
+	username := spec.Name
+	uidStr := spec.UIDNumber
+	result.DN = lc.UserDn(username)
+	if lc.Idempotent {
+		filter := lc.userFilter(username)
+		exists, uidNumber, serr := lc.existingNumber(lc.PeopleDn(), filter, "uidNumber")
+		if serr != nil {
+			err = serr
+			return
+		}
+		if exists {
+			if uidNumber != uidStr {
+				err = fmt.Errorf("user exists with uidNumber %s", uidNumber)
+				return
+			}
+			result.Status = StatusUnchanged
+			return
+		}
+	}
+	if err = lc.AddUserSpec(spec); err != nil {
+		return
+	}
+	result.Status = StatusCreated
+	return
+}
+
+// CreateGroup ... AddGroup, refusing existing groups unless idempotent and the gidNumber matches
+func (lc *LDAPClient) CreateGroup(groupname string, gidStr string) (result OperationResult, err error) {
+	result.DN = lc.GroupEntryDn(groupname)
+	filter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(groupname))
+	exists, gidNumber, err := lc.existingNumber(lc.GroupDn(), filter, "gidNumber")
+	if err != nil {
+		return
+	}
+	if exists {
+		if !lc.Idempotent {
+			err = fmt.Errorf("group %s has existed in ldap", groupname)
+			return
+		}
+		if gidNumber != gidStr {
+			err = fmt.Errorf("group exists with gidNumber %s", gidNumber)
+			return
+		}
+		result.Status = StatusUnchanged
+		return
+	}
+	if err = lc.AddGroup(groupname, gidStr); err != nil {
+		return
+	}
+	result.Status = StatusCreated
+	return
+}
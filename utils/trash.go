@@ -0,0 +1,361 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// generalizedLayout ... GeneralizedTime down to seconds, cut after the hour
+// or minute for values without them
+const generalizedLayout = "20060102150405"
+
+// parseGeneralizedTime ... GeneralizedTime as returned for createTimestamp or
+// whenCreated: YYYYMMDDHH[MM[SS]], a fraction of the last unit after . or ,
+// and Z or an offset like -0500, e.g. 20240102150405Z, 20240102150405.123456Z
+// of 389-DS or 20240102150405.0Z of Active Directory
+func parseGeneralizedTime(value string) (t time.Time, err error) {
+	invalid := fmt.Errorf("invalid generalized time %q", value)
+	s := value
+	loc := time.UTC
+	if strings.HasSuffix(s, "Z") {
+		s = s[:len(s)-1]
+	} else if i := strings.LastIndexAny(s, "+-"); i >= 0 {
+		offset := s[i+1:]
+		if len(offset) != 2 && len(offset) != 4 {
+			return t, invalid
+		}
+		hours, herr := strconv.Atoi(offset[:2])
+		minutes, merr := strconv.Atoi((offset + "00")[2:4])
+		if herr != nil || merr != nil || hours > 23 || minutes > 59 {
+			return t, invalid
+		}
+		seconds := hours*3600 + minutes*60
+		if s[i] == '-' {
+			seconds = -seconds
+		}
+		loc = time.FixedZone(s[i:], seconds)
+		s = s[:i]
+	} else {
+		return t, invalid
+	}
+	fraction := ""
+	if i := strings.IndexAny(s, ".,"); i >= 0 {
+		fraction = s[i+1:]
+		s = s[:i]
+		if fraction == "" || strings.Trim(fraction, "0123456789") != "" {
+			return t, invalid
+		}
+	}
+	units := map[int]time.Duration{10: time.Hour, 12: time.Minute, 14: time.Second}
+	unit, ok := units[len(s)]
+	if !ok {
+		return t, invalid
+	}
+	if t, err = time.ParseInLocation(generalizedLayout[:len(s)], s, loc); err != nil {
+		return t, invalid
+	}
+	if fraction != "" {
+		f, _ := strconv.ParseFloat("0."+fraction, 64)
+		t = t.Add(time.Duration(f * float64(unit)))
+	}
+	return
+}
+
+// TrashDn ... dn of the ou holding deleted users
+func (lc *LDAPClient) TrashDn() string {
+	return fmt.Sprintf("ou=Deleted,%s", lc.BaseDn)
+}
+
+// trashMembershipsDn ... dn of the ou below the trash recording the groups of trashed users
+func (lc *LDAPClient) trashMembershipsDn() string {
+	return fmt.Sprintf("ou=Memberships,%s", lc.TrashDn())
+}
+
+// trashMembershipDn ... dn of the record of the groups of trashed user
+func (lc *LDAPClient) trashMembershipDn(username string) string {
+	return fmt.Sprintf("cn=%s,%s", username, lc.trashMembershipsDn())
+}
+
+// reparent ... dn moved below parent, keeping its rdn
+func reparent(dn string, parent string) string {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return dn[:i] + "," + parent
+		}
+	}
+	return dn + "," + parent
+}
+
+// ArchiveUser ... append user entry to an LDIF file, or print it with
+// secrets redacted in dry run mode
+func (lc *LDAPClient) ArchiveUser(username string, path string) (err error) {
+	filter := lc.userFilter(username)
+	data, err := lc.Search(filter, []string{}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	if lc.DryRun {
+		fmt.Printf("# archive to %s\n", path)
+		return WriteLDIF(os.Stdout, []LdapResult{redactEntry(data[0])})
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if fi.Size() == 0 {
+		_, err = f.WriteString("version: 1\n\n")
+	} else {
+		_, err = f.WriteString("\n")
+	}
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(f, "# deleted %s\n", time.Now().UTC().Format(time.RFC3339))
+	return WriteLDIF(f, data)
+}
+
+// ensureTrashOu ... create ou=Deleted if missing
+func (lc *LDAPClient) ensureTrashOu() (err error) {
+	if lc.ExistUnder(lc.BaseDn, "(&(objectClass=organizationalUnit)(ou=Deleted))") {
+		return
+	}
+	addrequest := ldap.NewAddRequest(lc.TrashDn())
+	addrequest.Attribute("objectClass", []string{"top", "organizationalUnit"})
+	addrequest.Attribute("ou", []string{"Deleted"})
+	return lc.Add(addrequest)
+}
+
+// saveTrashedGroups ... record the groups of a trashed user as the seeAlso
+// of an organizationalRole in ou=Memberships,ou=Deleted, keeping them out of
+// the user entry, where description is single-valued in AD
+func (lc *LDAPClient) saveTrashedGroups(username string, groups []string) (err error) {
+	if !lc.ExistUnder(lc.TrashDn(), "(&(objectClass=organizationalUnit)(ou=Memberships))") {
+		addrequest := ldap.NewAddRequest(lc.trashMembershipsDn())
+		addrequest.Attribute("objectClass", []string{"top", "organizationalUnit"})
+		addrequest.Attribute("ou", []string{"Memberships"})
+		if err = lc.Add(addrequest); err != nil {
+			return
+		}
+	}
+	dns := make([]string, len(groups))
+	for i, groupname := range groups {
+		dns[i] = lc.GroupEntryDn(groupname)
+	}
+	addrequest := ldap.NewAddRequest(lc.trashMembershipDn(username))
+	addrequest.Attribute("objectClass", []string{"top", "organizationalRole"})
+	addrequest.Attribute("cn", []string{username})
+	addrequest.Attribute("seeAlso", dns)
+	return lc.Add(addrequest)
+}
+
+// trashedGroups ... groups recorded for trashed user, and whether there is a record
+func (lc *LDAPClient) trashedGroups(username string) (groups []string, found bool, err error) {
+	entry, err := lc.ReadEntry(lc.trashMembershipDn(username), []string{"seeAlso"})
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			err = nil
+		}
+		return
+	}
+	found = len(entry.Attributes) > 0
+	for _, dn := range entry.Values("seeAlso") {
+		rdn, _ := splitDN(dn)
+		values, perr := rdnValues(rdn)
+		if perr != nil {
+			err = perr
+			return
+		}
+		for _, v := range values {
+			if strings.EqualFold(v.Type, "cn") {
+				groups = append(groups, v.Value)
+			}
+		}
+	}
+	return
+}
+
+// dropTrashedGroups ... delete the record of the groups of trashed user, if any
+func (lc *LDAPClient) dropTrashedGroups(username string) (err error) {
+	_, found, err := lc.trashedGroups(username)
+	if err != nil || !found {
+		return
+	}
+	return lc.Del(ldap.NewDelRequest(lc.trashMembershipDn(username), nil))
+}
+
+// TrashUser ... move user to ou=Deleted, remembering its groups, undoing its steps if one fails
+func (lc *LDAPClient) TrashUser(username string) (err error) {
+	return lc.Transaction(func() error {
+		return lc.trashUser(username)
+	})
+}
+
+func (lc *LDAPClient) trashUser(username string) (err error) {
+	filter := lc.userFilter(username)
+	data, err := lc.Search(filter, []string{}, lc.PeopleDn())
+	if err != nil {
+		return
+	}
+	if lc.ExistUnder(lc.TrashDn(), filter) {
+		return errors.New("user is already in trash, purge or restore it first")
+	}
+	if err = lc.ensureTrashOu(); err != nil {
+		return
+	}
+	groups, err := lc.UserGroupNames(username)
+	if err != nil {
+		return
+	}
+
+	// ldap.v2 has no ModifyDN, so copy the entry and delete the original
+	addrequest := ldap.NewAddRequest(reparent(data[0].DN, lc.TrashDn()))
+	for _, k := range sortedAttrNames(data[0].Attributes) {
+		addrequest.Attribute(k, data[0].Attributes[k])
+	}
+	if err = lc.Add(addrequest); err != nil {
+		return
+	}
+	if len(groups) > 0 {
+		if err = lc.saveTrashedGroups(username, groups); err != nil {
+			return
+		}
+	}
+	return lc.DelUser(username)
+}
+
+// RestoreUser ... move user back from ou=Deleted and re-add its groups, undoing its steps if one fails
+func (lc *LDAPClient) RestoreUser(username string) (err error) {
+	return lc.Transaction(func() error {
+		return lc.restoreUser(username)
+	})
+}
+
+func (lc *LDAPClient) restoreUser(username string) (err error) {
+	filter := lc.userFilter(username)
+	data, err := lc.SearchEntries(filter, []string{}, lc.TrashDn())
+	if err != nil {
+		return
+	}
+	if len(data) == 0 {
+		return errors.New("user is not in trash")
+	}
+	if lc.ExistUnder(lc.PeopleDn(), filter) {
+		return errors.New("record has existed in ldap")
+	}
+
+	groups, _, err := lc.trashedGroups(username)
+	if err != nil {
+		return
+	}
+
+	addrequest := ldap.NewAddRequest(reparent(data[0].DN, lc.PeopleDn()))
+	for _, k := range sortedAttrNames(data[0].Attributes) {
+		addrequest.Attribute(k, data[0].Attributes[k])
+	}
+	if err = lc.Add(addrequest); err != nil {
+		return
+	}
+	for _, groupname := range groups {
+		groupFilter := fmt.Sprintf("(&(cn=%s))", ldap.EscapeFilter(groupname))
+		if !lc.ExistUnder(lc.GroupDn(), groupFilter) {
+			fmt.Printf("WARN: group %s no longer exists, skipped\n", groupname)
+			continue
+		}
+		if _, err = lc.ModifyMembers(groupname, []string{username}, nil); err != nil {
+			return
+		}
+	}
+	if err = lc.dropTrashedGroups(username); err != nil {
+		return
+	}
+	return lc.Del(ldap.NewDelRequest(data[0].DN, nil))
+}
+
+// PurgeTrash ... delete trashed users older than retention
+func (lc *LDAPClient) PurgeTrash(retention time.Duration) (purged []string, err error) {
+	login := lc.profile().LoginAttr
+	filter := fmt.Sprintf("(%s=*)", login)
+	data, err := lc.SearchEntries(filter, []string{login, "createTimestamp", "whenCreated"}, lc.TrashDn())
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(-retention)
+	for _, entry := range data {
+		created := entry.Values("createTimestamp")
+		if len(created) == 0 {
+			// Active Directory has whenCreated instead
+			created = entry.Values("whenCreated")
+		}
+		if len(created) == 0 {
+			fmt.Printf("WARN: %s has no createTimestamp or whenCreated, skipped\n", entry.DN)
+			continue
+		}
+		t, perr := parseGeneralizedTime(created[0])
+		if perr != nil {
+			fmt.Printf("WARN: %s: %s, skipped\n", entry.DN, perr.Error())
+			continue
+		}
+		if t.After(deadline) {
+			continue
+		}
+		username := entry.Values(login)[0]
+		if err = lc.Del(ldap.NewDelRequest(entry.DN, nil)); err != nil {
+			return
+		}
+		if err = lc.dropTrashedGroups(username); err != nil {
+			return
+		}
+		purged = append(purged, username)
+	}
+	return
+}
+
+// RestoreUser ... restore user from trash
+func RestoreUser(lc *LDAPClient, username string) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	err = lc.RestoreUser(username)
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
+
+// PurgeTrash ... purge trashed users older than retention
+func PurgeTrash(lc *LDAPClient, retention time.Duration) (err error) {
+	err = lc.Connect()
+	defer lc.Close()
+
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+		return
+	}
+	purged, err := lc.PurgeTrash(retention)
+	for _, username := range purged {
+		fmt.Println("purged", username)
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err.Error())
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseGeneralizedTime(t *testing.T) {
+	want := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"20240102150405Z", want},
+		{"20240102150405.0Z", want},
+		{"20240102150405.123456Z", want.Add(123456 * time.Microsecond)},
+		{"20240102150405,5Z", want.Add(500 * time.Millisecond)},
+		{"20240102100405-0500", want},
+		{"20240102200405+05", want},
+		{"20240102203405+0530", want},
+		{"202401021504Z", want.Add(-5 * time.Second)},
+		{"2024010215Z", want.Add(-4*time.Minute - 5*time.Second)},
+		{"2024010215.5Z", want.Add(26*time.Minute - 5*time.Second)},
+	}
+	for _, tt := range tests {
+		got, err := parseGeneralizedTime(tt.value)
+		if err != nil || !got.Equal(tt.want) {
+			t.Fatalf("%s: expected %v, got %v %v", tt.value, tt.want, got, err)
+		}
+	}
+	for _, value := range []string{"", "20240102150405", "2024-01-02T15:04:05Z", "20240102150405.Z",
+		"20240102150405.1aZ", "20240102150405+5", "20241302150405Z", "202401021504055Z"} {
+		if _, err := parseGeneralizedTime(value); err == nil {
+			t.Fatalf("%q parsed", value)
+		}
+	}
+}
+
+func Test_purgeTrash(t *testing.T) {
+	old := "20000101000000Z"
+	recent := time.Now().UTC().Format("20060102150405") + ".0Z"
+	entries := append(posixFixture()[:3],
+		fixture("ou=Deleted,dc=test,dc=com", "objectClass", "organizationalUnit"),
+		fixture("uid=dave,ou=Deleted,dc=test,dc=com", "uid", "dave", "createTimestamp", old),
+		fixture("uid=erin,ou=Deleted,dc=test,dc=com", "uid", "erin", "whenCreated", "20000101000000.0Z"),
+		fixture("uid=frank,ou=Deleted,dc=test,dc=com", "uid", "frank", "createTimestamp", recent),
+		fixture("uid=grace,ou=Deleted,dc=test,dc=com", "uid", "grace", "createTimestamp", "2000-01-01"),
+		fixture("uid=heidi,ou=Deleted,dc=test,dc=com", "uid", "heidi"))
+	s := newFakeServer(t, entries...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	var purged []string
+	out := captureStdout(t, func() {
+		var err error
+		if purged, err = lc.PurgeTrash(24 * time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !reflect.DeepEqual(purged, []string{"dave", "erin"}) {
+		t.Fatalf("unexpected purged users: %v", purged)
+	}
+	for _, dn := range []string{"uid=grace,ou=Deleted,dc=test,dc=com", "uid=heidi,ou=Deleted,dc=test,dc=com"} {
+		if !strings.Contains(out, "WARN: "+dn) {
+			t.Fatalf("no warning for %s:\n%s", dn, out)
+		}
+	}
+}
+
+func Test_trashKeepsGroupsApart(t *testing.T) {
+	entries := posixFixture()
+	entries[3].Attributes["description"] = []string{"managed-by-userctl"}
+	entries[4].Attributes["createTimestamp"] = []string{"20000101000000Z"}
+	s := newFakeServer(t, entries...)
+	defer s.Close()
+	lc := s.client("dc=test,dc=com", ProfileRFC2307)
+	lc.Membership = MembershipBoth
+	if err := lc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	for _, username := range []string{"alice", "bob"} {
+		if err := lc.TrashUser(username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice, ok := s.entry("uid=alice,ou=Deleted,dc=test,dc=com")
+	if !ok || !reflect.DeepEqual(alice.Values("description"), []string{"managed-by-userctl"}) {
+		t.Fatalf("trashed user changed: %v", alice.Attributes)
+	}
+	record, ok := s.entry("cn=alice,ou=Memberships,ou=Deleted,dc=test,dc=com")
+	if !ok || !reflect.DeepEqual(record.Values("seeAlso"), []string{"cn=dev,ou=Group,dc=test,dc=com"}) {
+		t.Fatalf("groups of trashed user not recorded: %v", record.Attributes)
+	}
+	if members, _ := lc.CurrentMembers("dev"); len(members) > 0 {
+		t.Fatalf("trashed users still members: %v", members)
+	}
+
+	if err := lc.RestoreUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	alice, _ = s.entry("uid=alice,ou=People,dc=test,dc=com")
+	if !reflect.DeepEqual(alice.Values("description"), []string{"managed-by-userctl"}) {
+		t.Fatalf("restored user changed: %v", alice.Attributes)
+	}
+	if members, _ := lc.CurrentMembers("dev"); !reflect.DeepEqual(members, []string{"alice"}) {
+		t.Fatalf("restored user not back in dev: %v", members)
+	}
+	if _, ok = s.entry("cn=alice,ou=Memberships,ou=Deleted,dc=test,dc=com"); ok {
+		t.Fatal("record of restored user kept")
+	}
+
+	captureStdout(t, func() {
+		if purged, err := lc.PurgeTrash(24 * time.Hour); err != nil || !reflect.DeepEqual(purged, []string{"bob"}) {
+			t.Fatalf("unexpected purge: %v %v", purged, err)
+		}
+	})
+	if _, ok = s.entry("cn=bob,ou=Memberships,ou=Deleted,dc=test,dc=com"); ok {
+		t.Fatal("record of purged user kept")
+	}
+}